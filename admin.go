@@ -0,0 +1,286 @@
+package cachet
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var errMonitorNotRunning = errors.New("monitor is not running")
+
+// MonitorStatus is the live state of a monitor, published after each tick
+type MonitorStatus struct {
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	Target         string   `json:"target"`
+	ComponentID    int      `json:"component_id"`
+	Enabled        bool     `json:"enabled"`
+	Paused         bool     `json:"paused"`
+	Running        bool     `json:"running"`
+	Features       []string `json:"features"`
+	Status         int      `json:"status"`
+	History        []bool   `json:"history"`
+	HistorySize    int      `json:"history_size"`
+	LastFailReason string   `json:"last_fail_reason"`
+	LastLag        int64    `json:"last_lag"`
+	IncidentID     int      `json:"incident_id,omitempty"`
+	IncidentStatus int      `json:"incident_status,omitempty"`
+	// the open incident is about performance issues only
+	PerformanceIncident bool      `json:"performance_incident,omitempty"`
+	Flapping            bool      `json:"flapping"`
+	Degraded            bool      `json:"performance_degraded"`
+	InMaintenance       bool      `json:"in_maintenance"`
+	LastTick            time.Time `json:"last_tick"`
+	// location writing the status and incidents when running with peers
+	Leader string `json:"leader,omitempty"`
+}
+
+// AdminServer exposes the state of the monitors over HTTP
+type AdminServer struct {
+	// disabled when empty
+	Listen string `json:"listen" yaml:"listen"`
+
+	mu       sync.Mutex
+	monitors []MonitorInterface
+	server   *http.Server
+}
+
+// publishStatus makes the current state available to the admin API
+func (mon *AbstractMonitor) publishStatus(iface MonitorInterface) {
+	status := MonitorStatus{
+		Name:           mon.Name,
+		Type:           mon.Type,
+		Target:         mon.Target,
+		ComponentID:    mon.ComponentID,
+		Enabled:        mon.Enabled,
+		Paused:         mon.paused,
+		Running:        true,
+		Features:       iface.Describe(),
+		Status:         mon.currentStatus,
+		History:        append([]bool{}, mon.history...),
+		HistorySize:    mon.HistorySize,
+		LastFailReason: mon.lastFailReason,
+		LastLag:        mon.lastLag,
+		Flapping:       mon.flapping,
+		Degraded:       mon.performanceDegraded,
+		InMaintenance:  mon.inMaintenance,
+		LastTick:       mon.lastTick,
+		Leader:         mon.peerLeader,
+	}
+	if mon.incident != nil {
+		status.IncidentID = mon.incident.ID
+		status.IncidentStatus = mon.incident.Status
+		status.PerformanceIncident = mon.incidentIsPerformance
+	}
+
+	mon.statusMu.Lock()
+	mon.status = status
+	mon.statusMu.Unlock()
+}
+
+// Status returns the state published after the last tick
+func (mon *AbstractMonitor) Status() MonitorStatus {
+	mon.statusMu.Lock()
+	defer mon.statusMu.Unlock()
+
+	if !mon.status.Running {
+		// never started
+		return MonitorStatus{
+			Name:        mon.Name,
+			Type:        mon.Type,
+			Target:      mon.Target,
+			ComponentID: mon.ComponentID,
+			HistorySize: mon.HistorySize,
+		}
+	}
+
+	return mon.status
+}
+
+// run executes fn in the monitor goroutine between two ticks and waits for it
+func (mon *AbstractMonitor) run(iface MonitorInterface, fn func()) error {
+	if mon.commandC == nil {
+		return errMonitorNotRunning
+	}
+
+	done := make(chan bool)
+	select {
+	case mon.commandC <- func() {
+		fn()
+		mon.publishStatus(iface)
+		close(done)
+	}:
+	case <-mon.doneC:
+		return errMonitorNotRunning
+	}
+	<-done
+
+	return nil
+}
+
+// setMonitors replaces the monitors served by the admin API
+func (srv *AdminServer) setMonitors(monitors []MonitorInterface) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.monitors = monitors
+}
+
+func (srv *AdminServer) findMonitor(name string) MonitorInterface {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for _, monitor := range srv.monitors {
+		if monitor.GetMonitor().Name == name {
+			return monitor
+		}
+	}
+
+	return nil
+}
+
+// Start listens if an address has been configured
+func (srv *AdminServer) Start(monitors []MonitorInterface) error {
+	srv.setMonitors(monitors)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if len(srv.Listen) == 0 || srv.server != nil {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", srv.Listen)
+	if err != nil {
+		return err
+	}
+
+	srv.server = &http.Server{Handler: srv}
+	go srv.server.Serve(ln)
+
+	logrus.Infof("Admin API listening on %s", ln.Addr())
+
+	return nil
+}
+
+// Stop closes the listener
+func (srv *AdminServer) Stop() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.server != nil {
+		srv.server.Close()
+		srv.server = nil
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// ServeHTTP handles GET /metrics, GET /monitors, GET /monitors/<name> and POST /monitors/<name>/(pause|resume|tick|reload)
+func (srv *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" {
+		srv.servePrometheus(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "monitors" || len(parts) > 3 {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != "GET" {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		srv.mu.Lock()
+		monitors := srv.monitors
+		srv.mu.Unlock()
+
+		statuses := []MonitorStatus{}
+		for _, monitor := range monitors {
+			statuses = append(statuses, monitor.GetMonitor().Status())
+		}
+		writeJSON(w, http.StatusOK, statuses)
+		return
+	}
+
+	monitor := srv.findMonitor(parts[1])
+	if monitor == nil {
+		writeJSONError(w, http.StatusNotFound, "unknown monitor "+parts[1])
+		return
+	}
+	mon := monitor.GetMonitor()
+
+	if len(parts) == 2 {
+		if r.Method != "GET" {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		writeJSON(w, http.StatusOK, mon.Status())
+		return
+	}
+
+	if r.Method != "POST" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var action func()
+	var actionErr error
+	switch parts[2] {
+	case "pause":
+		action = func() {
+			logrus.Infof("Monitor %s paused from the admin API", mon.Name)
+			mon.paused = true
+			mon.Enabled = false
+		}
+	case "resume":
+		action = func() {
+			logrus.Infof("Monitor %s resumed from the admin API", mon.Name)
+			mon.paused = false
+			mon.Enabled = true
+		}
+	case "tick":
+		action = func() {
+			mon.tick(monitor)
+		}
+	case "reload":
+		action = func() {
+			actionErr = mon.ReloadCachetData()
+		}
+	default:
+		writeJSONError(w, http.StatusNotFound, "unknown action "+parts[2])
+		return
+	}
+
+	if err := mon.run(monitor, action); err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if actionErr != nil {
+		writeJSONError(w, http.StatusBadGateway, actionErr.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, mon.Status())
+}
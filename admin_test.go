@@ -0,0 +1,70 @@
+package cachet
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func adminRequest(t *testing.T, srv *AdminServer, method, path string, v interface{}) int {
+	req := httptest.NewRequest(method, path, nil)
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, req)
+
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: cannot decode response: %v", method, path, err)
+		}
+	}
+
+	return rec.Code
+}
+
+func TestAdminServer(t *testing.T) {
+	cfg := &CachetMonitor{}
+	running := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "running", Interval: 3600, Timeout: 1, Enabled: true}}
+	stopped := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "stopped", Interval: 3600, Timeout: 1}}
+	for _, mon := range []*MockMonitor{running, stopped} {
+		mon.Validate()
+		mon.config = cfg
+	}
+
+	wg := &sync.WaitGroup{}
+	running.ClockStart(cfg, running, wg)
+	waitStarted(t, &CachetMonitor{Monitors: []MonitorInterface{running}})
+	defer func() {
+		running.ClockStop()
+		wg.Wait()
+	}()
+
+	srv := &AdminServer{}
+	srv.setMonitors([]MonitorInterface{running, stopped})
+
+	var statuses []MonitorStatus
+	if code := adminRequest(t, srv, "GET", "/monitors", &statuses); code != http.StatusOK || len(statuses) != 2 {
+		t.Fatalf("expected 2 monitors, got %d: %v", code, statuses)
+	}
+
+	var status MonitorStatus
+	if adminRequest(t, srv, "POST", "/monitors/running/pause", &status); status.Enabled || !status.Paused {
+		t.Errorf("monitor should be paused, got %+v", status)
+	}
+	if adminRequest(t, srv, "POST", "/monitors/running/resume", &status); !status.Enabled || status.Paused {
+		t.Errorf("monitor should be resumed, got %+v", status)
+	}
+	if adminRequest(t, srv, "POST", "/monitors/running/tick", &status); status.LastTick.IsZero() || len(status.History) != 1 {
+		t.Errorf("monitor should have ticked, got %+v", status)
+	}
+
+	if code := adminRequest(t, srv, "POST", "/monitors/stopped/tick", nil); code != http.StatusConflict {
+		t.Errorf("expected 409 for a monitor which is not running, got %d", code)
+	}
+	if code := adminRequest(t, srv, "GET", "/monitors/unknown", nil); code != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown monitor, got %d", code)
+	}
+	if code := adminRequest(t, srv, "GET", "/monitors/running/tick", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for GET on an action, got %d", code)
+	}
+}
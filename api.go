@@ -2,132 +2,348 @@ package cachet
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 )
 
+const DefaultAPITimeout = 10
+const DefaultAPIRetries = 3
+
+// first retry waits around this long, then exponentially more
+const defaultAPIRetryWait = 500 * time.Millisecond
+const maxAPIRetryWait = 30 * time.Second
+
+// ErrNotFound is returned when the requested Cachet resource does not exist
+var ErrNotFound = errors.New("Cachet API: resource not found")
+
+// ErrUnauthorized is returned when the API token is missing or invalid
+var ErrUnauthorized = errors.New("Cachet API: unauthorized (check the API token)")
+
+// APIError is returned when the Cachet API responds with an unexpected status code
+type APIError struct {
+	Status int
+	Body   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Cachet API responded with status %d: %s", e.Status, e.Body)
+}
+
 type CachetAPI struct {
 	URL      string `json:"url"`
 	Token    string `json:"token"`
 	Insecure bool   `json:"insecure"`
+
+	// seconds before a request times out
+	Timeout time.Duration `json:"timeout"`
+	// retries on network errors, 429 and 5xx responses (negative to disable)
+	Retries int `json:"retries"`
+
+	clientOnce sync.Once
+	client     *http.Client
+	retryWait  time.Duration
+
+	// records the writes instead of sending them (dry run)
+	DryRun *DryRunRecorder `json:"-"`
 }
 
 type CachetResponse struct {
 	Data json.RawMessage `json:"data"`
+	Meta struct {
+		// set on lists
+		Pagination struct {
+			Total       int `json:"total"`
+			CurrentPage int `json:"current_page"`
+			TotalPages  int `json:"total_pages"`
+		} `json:"pagination"`
+	} `json:"meta"`
 }
 
-// TODO: test
-func (api CachetAPI) Ping() error {
-	resp, _, err := api.NewRequest("GET", "/ping", nil)
-	if err != nil {
-		return err
-	}
+// httpClient returns the client shared by every request to this API
+func (api *CachetAPI) httpClient() *http.Client {
+	api.clientOnce.Do(func() {
+		timeout := api.Timeout
+		if timeout <= 0 {
+			timeout = DefaultAPITimeout
+		}
 
-	if resp.StatusCode != 200 {
-		return errors.New("API Responded with non-200 status code")
-	}
+		api.client = &http.Client{
+			Timeout: time.Duration(timeout * time.Second),
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   time.Duration(timeout * time.Second),
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				TLSClientConfig:     &tls.Config{InsecureSkipVerify: api.Insecure},
+				TLSHandshakeTimeout: time.Duration(timeout * time.Second),
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+	})
+
+	return api.client
+}
+
+func (api *CachetAPI) Ping(ctx context.Context) error {
+	_, _, err := api.NewRequest(ctx, "GET", "/ping", nil)
 
-	return nil
+	return err
 }
 
 // SendMetric adds a data point to a cachet monitor - Deprecated
-func (api CachetAPI) SendMetric(l *logrus.Entry, id int, lag int64) {
-	api.SendMetrics(l, "lag", []int { id }, lag)
+func (api *CachetAPI) SendMetric(ctx context.Context, l *logrus.Entry, id int, lag int64) error {
+	return api.SendMetrics(ctx, l, "lag", []int{id}, float64(lag))
 }
 
-// SendMetrics adds a data point to a cachet monitor
-func (api CachetAPI) SendMetrics(l *logrus.Entry, metricname string, arr []int, val int64) {
+// SendMetrics adds a data point to cachet metrics, returns the first error encountered
+func (api *CachetAPI) SendMetrics(ctx context.Context, l *logrus.Entry, metricname string, arr []int, val float64) error {
+	var firstErr error
+
+	timestamp := time.Now().Unix()
 	for _, v := range arr {
 		l.Infof("Sending %s metric ID:%d => %v", metricname, v, val)
 
-		jsonBytes, _ := json.Marshal(map[string]interface{}{
-			"value":     val,
-			"timestamp": time.Now().Unix(),
-		})
-
-		resp,_,_ := api.NewRequest("POST", "/metrics/"+strconv.Itoa(v)+"/points", jsonBytes)
-
-		if resp != nil {
-			if resp.StatusCode == 200 {
-				l.Debugf("Sending %s metric ID:%d => %v, returns %d", metricname, v, val, resp.StatusCode)
-			} else {
-				l.Warnf("Sending %s metric ID:%d => %v, returns %d", metricname, v, val, resp.StatusCode)
+		if err := api.SendMetricPoint(ctx, v, val, timestamp); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Sending %s metric ID:%d => %v: %v", metricname, v, val, err)
 			}
-		} else {
-			l.Warnf("Sending %s metric ID:%d => %v, no return", metricname, v, val)
+			continue
 		}
+
+		l.Debugf("Sent %s metric ID:%d => %v", metricname, v, val)
 	}
+
+	return firstErr
+}
+
+// SendMetricPoint adds a data point recorded at the given unix timestamp
+func (api *CachetAPI) SendMetricPoint(ctx context.Context, id int, val float64, timestamp int64) error {
+	jsonBytes, _ := json.Marshal(map[string]interface{}{
+		"value":     val,
+		"timestamp": timestamp,
+	})
+
+	_, _, err := api.NewRequest(ctx, "POST", "/metrics/"+strconv.Itoa(id)+"/points", jsonBytes)
+
+	return err
 }
 
-// TODO: test
 // GetComponentData
-func (api CachetAPI) GetComponentData(compid int) (Component) {
+func (api *CachetAPI) GetComponentData(ctx context.Context, compid int) (Component, error) {
 	logrus.Debugf("Getting data from component ID:%d", compid)
 
-	resp, body, err := api.NewRequest("GET", "/components/"+strconv.Itoa(compid), []byte(""))
+	var compInfo Component
 
-	if err != nil || resp.StatusCode != 200 {
-		logrus.Warnf("Could not get data from component (id: %d, status: %d, err: %v)", compid, resp.StatusCode, err)
+	_, body, err := api.NewRequest(ctx, "GET", "/components/"+strconv.Itoa(compid), nil)
+	if err != nil {
+		return compInfo, err
 	}
 
-	var compInfo Component
-
 	err = json.Unmarshal(body.Data, &compInfo)
 
-	return compInfo
+	if api.DryRun != nil {
+		if status, ok := api.DryRun.componentStatus(compid); ok {
+			compInfo.Status = status
+		}
+	}
+
+	return compInfo, err
 }
 
-// SetComponentStatus
-func (api CachetAPI) SetComponentStatus(comp *AbstractMonitor, status int) (Component) {
+// SetComponentStatus - the monitor's current status is only updated on success
+func (api *CachetAPI) SetComponentStatus(ctx context.Context, comp *AbstractMonitor, status int) (Component, error) {
 	logrus.Debugf("Setting new status (%d) to component ID: %d (instead of %d)", status, comp.ComponentID, comp.currentStatus)
 
+	compInfo, err := api.UpdateComponentStatus(ctx, comp.ComponentID, status)
+	if err != nil {
+		return compInfo, err
+	}
+	comp.currentStatus = status
+
+	return compInfo, nil
+}
+
+// UpdateComponentStatus sets the status of a component
+func (api *CachetAPI) UpdateComponentStatus(ctx context.Context, id int, status int) (Component, error) {
+	var compInfo Component
+
 	jsonBytes, _ := json.Marshal(map[string]interface{}{
-		"status":     status,
+		"status": status,
 	})
 
-	resp, body, err := api.NewRequest("PUT", "/components/"+strconv.Itoa(comp.ComponentID), jsonBytes)
+	_, body, err := api.NewRequest(ctx, "PUT", "/components/"+strconv.Itoa(id), jsonBytes)
+	if err != nil {
+		return compInfo, err
+	}
+
+	err = json.Unmarshal(body.Data, &compInfo)
+
+	return compInfo, err
+}
 
-	if err != nil || resp.StatusCode != 200 {
-		logrus.Warnf("Could not get data from component (id: %d, status: %d, err: %v)", comp.ComponentID, resp.StatusCode, err)
+// NewRequest sends a request, retrying on network errors, 429 and 5xx responses (see shouldRetry).
+// Non-2xx responses are returned as ErrNotFound, ErrUnauthorized or *APIError.
+// During a dry run, only GET requests are sent: the others are recorded and answered with their payload,
+// and the reads about the components created meanwhile are answered by the recorder.
+func (api *CachetAPI) NewRequest(ctx context.Context, requestType, url string, reqBody []byte) (*http.Response, CachetResponse, error) {
+	if api.DryRun != nil && requestType != "GET" {
+		return nil, api.DryRun.record(requestType, url, reqBody), nil
+	} else if api.DryRun != nil {
+		if body, ok := api.DryRun.read(url); ok {
+			return nil, body, nil
+		}
 	}
-	comp.currentStatus = status
 
-	var compInfo Component
+	retries := api.Retries
+	if retries == 0 {
+		retries = DefaultAPIRetries
+	} else if retries < 0 {
+		retries = 0
+	}
 
-	err = json.Unmarshal(body.Data, &compInfo)
+	for attempt := 0; ; attempt++ {
+		res, body, err := api.do(ctx, requestType, url, reqBody)
+		if err == nil || attempt >= retries || !api.shouldRetry(ctx, requestType, err) {
+			if err != nil {
+				promAPIErrors.add(1, requestType, promEndpoint(url))
+			}
+			return res, body, err
+		}
 
-	return compInfo
+		wait := api.backoff(attempt, res)
+		logrus.Debugf("%s %s failed (%v), retrying in %v", requestType, url, err, wait)
+
+		select {
+		case <-ctx.Done():
+			promAPIErrors.add(1, requestType, promEndpoint(url))
+			return res, body, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
 }
 
-// TODO: test
-// NewRequest wraps http.NewRequest
-func (api CachetAPI) NewRequest(requestType, url string, reqBody []byte) (*http.Response, CachetResponse, error) {
-	req, err := http.NewRequest(requestType, api.URL+url, bytes.NewBuffer(reqBody))
+// do sends a single request and decodes the response
+func (api *CachetAPI) do(ctx context.Context, requestType, url string, reqBody []byte) (*http.Response, CachetResponse, error) {
+	var body CachetResponse
+
+	req, err := http.NewRequest(requestType, api.URL+url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, body, err
+	}
+	req = req.WithContext(ctx)
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("X-Cachet-Token", api.Token)
 
-	transport := http.DefaultTransport.(*http.Transport)
-	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: api.Insecure}
-	client := &http.Client{
-		Transport: transport,
+	start := time.Now()
+	res, err := api.httpClient().Do(req)
+	promAPIDuration.observe(time.Since(start).Seconds(), requestType, promEndpoint(url))
+	if err != nil {
+		promAPIRequests.add(1, requestType, promEndpoint(url), "error")
+		return nil, body, err
 	}
+	defer res.Body.Close()
+	promAPIRequests.add(1, requestType, promEndpoint(url), strconv.Itoa(res.StatusCode))
 
-	res, err := client.Do(req)
+	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return nil, CachetResponse{}, err
+		return res, body, err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return res, body, ErrNotFound
+	case res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return res, body, ErrUnauthorized
+	case res.StatusCode < 200 || res.StatusCode >= 300:
+		return res, body, &APIError{Status: res.StatusCode, Body: string(data)}
+	}
+
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &body); err != nil {
+			return res, body, fmt.Errorf("Cannot parse Cachet API response: %v", err)
+		}
 	}
 
-	var body struct {
-		Data json.RawMessage `json:"data"`
+	return res, body, nil
+}
+
+// shouldRetry retries GET and PUT requests on transient errors. POST requests (incidents, updates,
+// metric points) are not idempotent: they are only retried when Cachet cannot have processed them
+func (api *CachetAPI) shouldRetry(ctx context.Context, requestType string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	switch requestType {
+	case "GET", "HEAD", "PUT", "DELETE":
+		return isTransientError(err)
+	}
+
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.Status == http.StatusTooManyRequests
+	}
+
+	return isDialError(err)
+}
+
+// isTransientError tells whether a failed request may succeed later (network errors, 429 and 5xx responses)
+func isTransientError(err error) bool {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr.Status == http.StatusTooManyRequests || apiErr.Status >= 500
+	}
+
+	// network errors (*url.Error implements net.Error)
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// isDialError tells whether a request failed before a connection was made
+func isDialError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
+
+// backoff returns the exponential wait (with jitter) before the next attempt
+func (api *CachetAPI) backoff(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			wait := time.Duration(seconds) * time.Second
+			if wait > maxAPIRetryWait {
+				wait = maxAPIRetryWait
+			}
+			return wait
+		}
+	}
+
+	wait := api.retryWait
+	if wait <= 0 {
+		wait = defaultAPIRetryWait
+	}
+	wait = wait << uint(attempt)
+	if wait > maxAPIRetryWait {
+		wait = maxAPIRetryWait
 	}
-	err = json.NewDecoder(res.Body).Decode(&body)
 
-	return res, body, err
+	// equal jitter: between wait/2 and wait
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
 }
@@ -0,0 +1,102 @@
+package cachet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewRequestRetries(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data":{"id":1}}`))
+	}))
+	defer srv.Close()
+
+	api := &CachetAPI{URL: srv.URL, retryWait: time.Millisecond}
+	if _, _, err := api.NewRequest(context.Background(), "GET", "/ping", nil); err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+
+	calls = 0
+	api = &CachetAPI{URL: srv.URL, Retries: -1, retryWait: time.Millisecond}
+	_, _, err := api.NewRequest(context.Background(), "GET", "/ping", nil)
+	if apiErr, ok := err.(*APIError); !ok || apiErr.Status != http.StatusServiceUnavailable {
+		t.Errorf("expected a 503 APIError without retries, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected a single attempt, got %d", calls)
+	}
+}
+
+func TestNewRequestRetriesWrites(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	// the incident may have been created before the 503
+	api := &CachetAPI{URL: srv.URL, retryWait: time.Millisecond}
+	if _, _, err := api.NewRequest(context.Background(), "POST", "/incidents", []byte(`{}`)); err == nil || calls != 1 {
+		t.Errorf("expected a single POST attempt on a 503, got %d (%v)", calls, err)
+	}
+
+	calls = 0
+	api.NewRequest(context.Background(), "PUT", "/components/1", []byte(`{}`))
+	if calls != DefaultAPIRetries+1 {
+		t.Errorf("expected PUT to be retried, got %d attempts", calls)
+	}
+
+	calls = 0
+	status = http.StatusTooManyRequests
+	api.NewRequest(context.Background(), "POST", "/metrics/1/points", []byte(`{}`))
+	if calls != DefaultAPIRetries+1 {
+		t.Errorf("expected a rate limited POST to be retried, got %d attempts", calls)
+	}
+
+	// nothing listens anymore: the request never reaches Cachet
+	srv.Close()
+	api = &CachetAPI{URL: srv.URL, retryWait: time.Millisecond}
+	_, _, err := api.NewRequest(context.Background(), "POST", "/metrics/1/points", []byte(`{}`))
+	if err == nil || !api.shouldRetry(context.Background(), "POST", err) {
+		t.Errorf("expected a POST to be retried when the connection is refused, got %v", err)
+	}
+}
+
+func TestNewRequestErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/private":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	defer srv.Close()
+
+	api := &CachetAPI{URL: srv.URL, retryWait: time.Millisecond}
+	if _, _, err := api.NewRequest(context.Background(), "GET", "/missing", nil); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, _, err := api.NewRequest(context.Background(), "GET", "/private", nil); err != ErrUnauthorized {
+		t.Errorf("expected ErrUnauthorized, got %v", err)
+	}
+	if _, _, err := api.NewRequest(context.Background(), "POST", "/invalid", nil); err == nil {
+		t.Error("expected an error on 422")
+	}
+}
@@ -0,0 +1,677 @@
+// Package cachettest provides a fake Cachet API v1 for tests: the subset used by cachet-monitor
+// (ping, components and their groups, incidents and their updates, metrics and their points,
+// schedules) served by an httptest.Server, with inspectable state and fault injection.
+package cachettest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultToken is the API token expected by a new server
+const DefaultToken = "cachettest"
+
+// the default page size of Cachet lists
+const defaultPerPage = 20
+
+var (
+	componentPath      = regexp.MustCompile(`^/components/(\d+)$`)
+	incidentPath       = regexp.MustCompile(`^/incidents/(\d+)$`)
+	incidentUpdatePath = regexp.MustCompile(`^/incidents/(\d+)/updates$`)
+	metricPointsPath   = regexp.MustCompile(`^/metrics/(\d+)/points$`)
+)
+
+// Component Cachet data model
+type Component struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Link        string `json:"link"`
+	Status      int    `json:"status"`
+	Enabled     bool   `json:"enabled"`
+	GroupID     int    `json:"group_id"`
+}
+
+// ComponentGroup Cachet data model
+type ComponentGroup struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Incident Cachet data model
+type Incident struct {
+	ID              int    `json:"id"`
+	Name            string `json:"name"`
+	Message         string `json:"message"`
+	Status          int    `json:"status"`
+	Visible         int    `json:"visible"`
+	Notify          bool   `json:"notify"`
+	ComponentID     int    `json:"component_id"`
+	ComponentStatus int    `json:"component_status"`
+
+	// posted to /incidents/:id/updates
+	Updates []IncidentUpdate `json:"-"`
+}
+
+// IncidentUpdate Cachet data model
+type IncidentUpdate struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Metric Cachet data model
+type Metric struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	Suffix       string  `json:"suffix"`
+	Description  string  `json:"description"`
+	CalcType     int     `json:"calc_type"`
+	DefaultValue float64 `json:"default_value"`
+	DisplayChart int     `json:"display_chart"`
+}
+
+// MetricPoint Cachet data model
+type MetricPoint struct {
+	MetricID  int     `json:"metric_id"`
+	Value     float64 `json:"value"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// ScheduleComponent links a schedule to a component
+type ScheduleComponent struct {
+	ID          int `json:"id"`
+	ComponentID int `json:"component_id"`
+}
+
+// Schedule Cachet data model (scheduled maintenance)
+type Schedule struct {
+	ID          int                 `json:"id"`
+	Name        string              `json:"name"`
+	Status      int                 `json:"status"`
+	ScheduledAt string              `json:"scheduled_at"`
+	CompletedAt string              `json:"completed_at"`
+	Components  []ScheduleComponent `json:"components"`
+}
+
+// Request is a request received by the server
+type Request struct {
+	Method string
+	// path and query, without the API prefix
+	URL  string
+	Body string
+}
+
+// Fault changes the response to the matching requests
+type Fault struct {
+	// matching requests: method ("" for any) and path prefix ("" for any)
+	Method string
+	Path   string
+	// number of matching requests affected (0 for all of them)
+	Times int
+
+	// delay before responding
+	Latency time.Duration
+	// responds with this status code instead of handling the request (e.g. 500)
+	Status int
+	// responds 200 with a truncated JSON body instead of handling the request
+	Malformed bool
+}
+
+func (fault *Fault) matches(r *http.Request) bool {
+	return (len(fault.Method) == 0 || fault.Method == r.Method) && strings.HasPrefix(r.URL.Path, fault.Path)
+}
+
+// Server is a fake Cachet API, the API URL is Server.URL
+type Server struct {
+	*httptest.Server
+	// expected in the X-Cachet-Token header (the ping is public), empty to accept any token
+	Token string
+	// when set, the page size of every list whatever the per_page parameter, to test pagination
+	PerPage int
+
+	mu         sync.Mutex
+	components map[int]*Component
+	groups     map[int]*ComponentGroup
+	metrics    map[int]*Metric
+	incidents  []*Incident
+	points     []MetricPoint
+	schedules  []Schedule
+	requests   []Request
+	faults     []*Fault
+}
+
+// NewServer starts a fake Cachet API expecting DefaultToken, callers should Close it
+func NewServer() *Server {
+	s := &Server{
+		Token:      DefaultToken,
+		components: map[int]*Component{},
+		groups:     map[int]*ComponentGroup{},
+		metrics:    map[int]*Metric{},
+	}
+	s.Server = httptest.NewServer(s)
+
+	return s
+}
+
+// AddComponent creates a component (the next ID when none is set, operational when no status is set)
+func (s *Server) AddComponent(component Component) Component {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addComponent(component)
+}
+
+// addComponent creates a component, with the lock held
+func (s *Server) addComponent(component Component) Component {
+	if component.ID == 0 {
+		component.ID = nextID(s.componentIDs())
+	}
+	if component.Status == 0 {
+		component.Status = 1
+	}
+	s.components[component.ID] = &component
+
+	return component
+}
+
+// Component returns a component
+func (s *Server) Component(id int) (Component, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	component, ok := s.components[id]
+	if !ok {
+		return Component{}, false
+	}
+
+	return *component, true
+}
+
+// AddGroup creates a component group (the next ID when none is set)
+func (s *Server) AddGroup(group ComponentGroup) ComponentGroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addGroup(group)
+}
+
+// addGroup creates a component group, with the lock held
+func (s *Server) addGroup(group ComponentGroup) ComponentGroup {
+	if group.ID == 0 {
+		group.ID = nextID(s.groupIDs())
+	}
+	s.groups[group.ID] = &group
+
+	return group
+}
+
+// Groups returns the component groups, sorted by ID
+func (s *Server) Groups() []ComponentGroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	groups := []ComponentGroup{}
+	for _, id := range s.groupIDs() {
+		groups = append(groups, *s.groups[id])
+	}
+
+	return groups
+}
+
+// AddMetric creates a metric (the next ID when none is set)
+func (s *Server) AddMetric(metric Metric) Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addMetric(metric)
+}
+
+// addMetric creates a metric, with the lock held
+func (s *Server) addMetric(metric Metric) Metric {
+	if metric.ID == 0 {
+		metric.ID = nextID(s.metricIDs())
+	}
+	s.metrics[metric.ID] = &metric
+
+	return metric
+}
+
+// Metrics returns the metrics, sorted by ID
+func (s *Server) Metrics() []Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	metrics := []Metric{}
+	for _, id := range s.metricIDs() {
+		metrics = append(metrics, *s.metrics[id])
+	}
+
+	return metrics
+}
+
+// AddIncident creates an incident, as if opened before the test
+func (s *Server) AddIncident(incident Incident) Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incident.ID = len(s.incidents) + 1
+	s.incidents = append(s.incidents, &incident)
+	s.applyComponentStatus(&incident)
+
+	return incident
+}
+
+// Incidents returns the incidents with their updates, oldest first
+func (s *Server) Incidents() []Incident {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incidents := []Incident{}
+	for _, incident := range s.incidents {
+		copied := *incident
+		copied.Updates = append([]IncidentUpdate{}, incident.Updates...)
+		incidents = append(incidents, copied)
+	}
+
+	return incidents
+}
+
+// MetricPoints returns the points added to a metric, oldest first
+func (s *Server) MetricPoints(metricID int) []MetricPoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	points := []MetricPoint{}
+	for _, point := range s.points {
+		if point.MetricID == metricID {
+			points = append(points, point)
+		}
+	}
+
+	return points
+}
+
+// AddSchedule creates a scheduled maintenance
+func (s *Server) AddSchedule(schedule Schedule) Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule.ID = len(s.schedules) + 1
+	s.schedules = append(s.schedules, schedule)
+
+	return schedule
+}
+
+// Requests returns the requests received so far, faulty ones included
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request{}, s.requests...)
+}
+
+// Inject adds a fault, faults are applied in the order they were added
+func (s *Server) Inject(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes all the faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.faults = nil
+}
+
+// fault returns the first fault matching the request, and uses it up
+func (s *Server) fault(r *http.Request) *Fault {
+	for i, fault := range s.faults {
+		if !fault.matches(r) {
+			continue
+		}
+
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		copied := *fault
+
+		return &copied
+	}
+
+	return nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, URL: r.URL.RequestURI(), Body: string(body)})
+	fault := s.fault(r)
+	s.mu.Unlock()
+
+	if fault != nil {
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.Status > 0 {
+			w.WriteHeader(fault.Status)
+			w.Write([]byte(`{"errors":[{"status":` + strconv.Itoa(fault.Status) + `,"title":"Injected fault"}]}`))
+			return
+		}
+		if fault.Malformed {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"data":{"id":`))
+			return
+		}
+	}
+
+	if r.URL.Path != "/ping" && len(s.Token) > 0 && r.Header.Get("X-Cachet-Token") != s.Token {
+		s.writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status, response := s.handle(r, body)
+	if status != http.StatusOK {
+		s.writeError(w, status, http.StatusText(status))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, title string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]interface{}{{"status": status, "title": title}},
+	})
+}
+
+// handle serves a request, with the lock held
+func (s *Server) handle(r *http.Request, body []byte) (int, interface{}) {
+	path := r.URL.Path
+
+	switch {
+	case r.Method == "GET" && path == "/ping":
+		return http.StatusOK, map[string]interface{}{"data": "Pong!"}
+
+	case r.Method == "GET" && path == "/components":
+		components := []interface{}{}
+		for _, id := range s.componentIDs() {
+			if component := s.components[id]; matchesName(r, component.Name) {
+				components = append(components, *component)
+			}
+		}
+		return s.page(r, components)
+
+	case r.Method == "POST" && path == "/components":
+		component := Component{Enabled: true}
+		if err := json.Unmarshal(body, &component); err != nil {
+			return http.StatusBadRequest, nil
+		}
+		component.ID = 0
+		return http.StatusOK, map[string]interface{}{"data": s.addComponent(component)}
+
+	case r.Method == "GET" && path == "/components/groups":
+		groups := []interface{}{}
+		for _, id := range s.groupIDs() {
+			if group := s.groups[id]; matchesName(r, group.Name) {
+				groups = append(groups, *group)
+			}
+		}
+		return s.page(r, groups)
+
+	case r.Method == "POST" && path == "/components/groups":
+		var group ComponentGroup
+		if err := json.Unmarshal(body, &group); err != nil {
+			return http.StatusBadRequest, nil
+		}
+		group.ID = 0
+		return http.StatusOK, map[string]interface{}{"data": s.addGroup(group)}
+
+	case componentPath.MatchString(path):
+		id, _ := strconv.Atoi(componentPath.FindStringSubmatch(path)[1])
+		component, ok := s.components[id]
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			updated := *component
+			if err := json.Unmarshal(body, &updated); err != nil {
+				return http.StatusBadRequest, nil
+			}
+			updated.ID = id
+			*component = updated
+		default:
+			return http.StatusMethodNotAllowed, nil
+		}
+		return http.StatusOK, map[string]interface{}{"data": *component}
+
+	case r.Method == "GET" && path == "/incidents":
+		return s.page(r, s.listIncidents(r))
+
+	case r.Method == "POST" && path == "/incidents":
+		incident := &Incident{Visible: 1}
+		if err := json.Unmarshal(body, incident); err != nil {
+			return http.StatusBadRequest, nil
+		}
+		incident.ID = len(s.incidents) + 1
+		s.incidents = append(s.incidents, incident)
+		s.applyComponentStatus(incident)
+		return http.StatusOK, map[string]interface{}{"data": *incident}
+
+	case incidentPath.MatchString(path):
+		id, _ := strconv.Atoi(incidentPath.FindStringSubmatch(path)[1])
+		if id < 1 || id > len(s.incidents) {
+			return http.StatusNotFound, nil
+		}
+		incident := s.incidents[id-1]
+		switch r.Method {
+		case "GET":
+		case "PUT":
+			updated := *incident
+			if err := json.Unmarshal(body, &updated); err != nil {
+				return http.StatusBadRequest, nil
+			}
+			updated.ID = id
+			*incident = updated
+			s.applyComponentStatus(incident)
+		default:
+			return http.StatusMethodNotAllowed, nil
+		}
+		return http.StatusOK, map[string]interface{}{"data": *incident}
+
+	case r.Method == "POST" && incidentUpdatePath.MatchString(path):
+		id, _ := strconv.Atoi(incidentUpdatePath.FindStringSubmatch(path)[1])
+		if id < 1 || id > len(s.incidents) {
+			return http.StatusNotFound, nil
+		}
+		var update IncidentUpdate
+		if err := json.Unmarshal(body, &update); err != nil {
+			return http.StatusBadRequest, nil
+		}
+		incident := s.incidents[id-1]
+		incident.Updates = append(incident.Updates, update)
+		if update.Status > 0 {
+			incident.Status = update.Status
+		}
+		return http.StatusOK, map[string]interface{}{"data": update}
+
+	case r.Method == "GET" && path == "/metrics":
+		metrics := []interface{}{}
+		for _, id := range s.metricIDs() {
+			if metric := s.metrics[id]; matchesName(r, metric.Name) {
+				metrics = append(metrics, *metric)
+			}
+		}
+		return s.page(r, metrics)
+
+	case r.Method == "POST" && path == "/metrics":
+		var metric Metric
+		if err := json.Unmarshal(body, &metric); err != nil {
+			return http.StatusBadRequest, nil
+		}
+		metric.ID = 0
+		return http.StatusOK, map[string]interface{}{"data": s.addMetric(metric)}
+
+	case r.Method == "POST" && metricPointsPath.MatchString(path):
+		id, _ := strconv.Atoi(metricPointsPath.FindStringSubmatch(path)[1])
+		point := MetricPoint{MetricID: id}
+		if err := json.Unmarshal(body, &point); err != nil {
+			return http.StatusBadRequest, nil
+		}
+		point.MetricID = id
+		if point.Timestamp == 0 {
+			point.Timestamp = time.Now().Unix()
+		}
+		s.points = append(s.points, point)
+		return http.StatusOK, map[string]interface{}{"data": point}
+
+	case r.Method == "GET" && path == "/schedules":
+		schedules := []interface{}{}
+		for _, schedule := range s.schedules {
+			schedules = append(schedules, schedule)
+		}
+		return s.page(r, schedules)
+	}
+
+	return http.StatusNotFound, nil
+}
+
+func (s *Server) componentIDs() []int {
+	ids := []int{}
+	for id := range s.components {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+func (s *Server) groupIDs() []int {
+	ids := []int{}
+	for id := range s.groups {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+func (s *Server) metricIDs() []int {
+	ids := []int{}
+	for id := range s.metrics {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+// nextID returns the ID following the highest of the sorted IDs
+func nextID(ids []int) int {
+	if len(ids) == 0 {
+		return 1
+	}
+
+	return ids[len(ids)-1] + 1
+}
+
+// matchesName tells whether the name passes the name filter of a list, if any
+func matchesName(r *http.Request, name string) bool {
+	filter := r.URL.Query().Get("name")
+
+	return len(filter) == 0 || filter == name
+}
+
+// listIncidents filters incidents by component_id, sorted by id (order=desc for the latest first)
+func (s *Server) listIncidents(r *http.Request) []interface{} {
+	query := r.URL.Query()
+	componentID, _ := strconv.Atoi(query.Get("component_id"))
+
+	incidents := []interface{}{}
+	for i := range s.incidents {
+		incident := s.incidents[i]
+		if query.Get("order") == "desc" {
+			incident = s.incidents[len(s.incidents)-1-i]
+		}
+		if componentID == 0 || incident.ComponentID == componentID {
+			incidents = append(incidents, *incident)
+		}
+	}
+
+	return incidents
+}
+
+// applyComponentStatus sets the status of the incident's component, as Cachet does
+func (s *Server) applyComponentStatus(incident *Incident) {
+	if component, ok := s.components[incident.ComponentID]; ok && incident.ComponentStatus > 0 {
+		component.Status = incident.ComponentStatus
+	}
+}
+
+// page returns a page of a list (page and per_page parameters) with its pagination
+func (s *Server) page(r *http.Request, items []interface{}) (int, interface{}) {
+	perPage, err := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if err != nil || perPage <= 0 {
+		perPage = defaultPerPage
+	}
+	if s.PerPage > 0 {
+		perPage = s.PerPage
+	}
+	current, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || current <= 0 {
+		current = 1
+	}
+
+	totalPages := (len(items) + perPage - 1) / perPage
+	if totalPages == 0 {
+		totalPages = 1
+	}
+
+	start := (current - 1) * perPage
+	if start > len(items) {
+		start = len(items)
+	}
+	end := start + perPage
+	if end > len(items) {
+		end = len(items)
+	}
+
+	return http.StatusOK, map[string]interface{}{
+		"data": items[start:end],
+		"meta": map[string]interface{}{
+			"pagination": map[string]interface{}{
+				"total":        len(items),
+				"count":        end - start,
+				"per_page":     perPage,
+				"current_page": current,
+				"total_pages":  totalPages,
+			},
+		},
+	}
+}
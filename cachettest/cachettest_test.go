@@ -0,0 +1,173 @@
+package cachettest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func request(t *testing.T, s *Server, method, url, body string) (int, map[string]interface{}) {
+	req, err := http.NewRequest(method, s.URL+url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Cachet-Token", s.Token)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	data, _ := ioutil.ReadAll(res.Body)
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(data, &response); err != nil {
+		response["raw"] = string(data)
+	}
+
+	return res.StatusCode, response
+}
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	web := s.AddComponent(Component{Name: "Website"})
+	s.AddComponent(Component{Name: "API", Status: 2})
+
+	if status, response := request(t, s, "GET", "/ping", ""); status != http.StatusOK || response["data"] != "Pong!" {
+		t.Errorf("unexpected ping: %d %v", status, response)
+	}
+
+	if status, response := request(t, s, "GET", "/components?per_page=1&page=2", ""); status != http.StatusOK {
+		t.Errorf("unexpected list: %d", status)
+	} else if data := response["data"].([]interface{}); len(data) != 1 || data[0].(map[string]interface{})["name"] != "API" {
+		t.Errorf("expected the second page to hold the API component, got %v", response)
+	}
+
+	if status, _ := request(t, s, "PUT", "/components/1", `{"status":4}`); status != http.StatusOK {
+		t.Errorf("unexpected update: %d", status)
+	}
+	if component, _ := s.Component(web.ID); component.Status != 4 || component.Name != "Website" {
+		t.Errorf("expected only the status to change, got %+v", component)
+	}
+	if status, _ := request(t, s, "GET", "/components/9", ""); status != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown component, got %d", status)
+	}
+
+	request(t, s, "POST", "/incidents", `{"name":"down","status":1,"component_id":1,"component_status":3}`)
+	request(t, s, "POST", "/incidents/1/updates", `{"status":2,"message":"found it"}`)
+	request(t, s, "PUT", "/incidents/1", `{"status":4,"component_status":1}`)
+	incidents := s.Incidents()
+	if len(incidents) != 1 || incidents[0].Status != 4 || incidents[0].Name != "down" || len(incidents[0].Updates) != 1 {
+		t.Errorf("unexpected incidents: %+v", incidents)
+	}
+	if component, _ := s.Component(web.ID); component.Status != 1 {
+		t.Errorf("expected the incident to set the component status, got %d", component.Status)
+	}
+	if _, response := request(t, s, "GET", "/incidents?component_id=2", ""); len(response["data"].([]interface{})) != 0 {
+		t.Errorf("expected no incident for component 2, got %v", response["data"])
+	}
+
+	request(t, s, "POST", "/metrics/3/points", `{"value":12.5,"timestamp":1500000000}`)
+	if points := s.MetricPoints(3); len(points) != 1 || points[0].Value != 12.5 {
+		t.Errorf("unexpected metric points: %v", points)
+	}
+
+	s.AddSchedule(Schedule{Name: "upgrade", Status: 1, Components: []ScheduleComponent{{ComponentID: 1}}})
+	if _, response := request(t, s, "GET", "/schedules", ""); len(response["data"].([]interface{})) != 1 {
+		t.Errorf("expected a schedule, got %v", response)
+	}
+
+	res, err := http.Get(s.URL + "/components")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without token, got %d", res.StatusCode)
+	}
+
+	if requests := s.Requests(); len(requests) != 11 || requests[2].Method != "PUT" || requests[2].Body != `{"status":4}` {
+		t.Errorf("unexpected requests: %v", requests)
+	}
+
+	for _, url := range []string{"/incidents/0", "/incidents/2"} {
+		if status, _ := request(t, s, "PUT", url, `{"status":4}`); status != http.StatusNotFound {
+			t.Errorf("expected 404 for %s, got %d", url, status)
+		}
+		if status, _ := request(t, s, "POST", url+"/updates", `{"status":4}`); status != http.StatusNotFound {
+			t.Errorf("expected 404 for %s/updates, got %d", url, status)
+		}
+	}
+}
+
+func TestServerProvisioning(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.AddGroup(ComponentGroup{ID: 4, Name: "Web"})
+	s.AddMetric(Metric{Name: "Latency"})
+
+	if status, response := request(t, s, "POST", "/components/groups", `{"name":"Backend"}`); status != http.StatusOK || response["data"].(map[string]interface{})["id"] != 5.0 {
+		t.Errorf("expected the next group ID, got %d %v", status, response)
+	}
+	if _, response := request(t, s, "GET", "/components/groups?name=Backend", ""); len(response["data"].([]interface{})) != 1 {
+		t.Errorf("expected the group to be found by name, got %v", response)
+	}
+	if groups := s.Groups(); len(groups) != 2 || groups[1].Name != "Backend" {
+		t.Errorf("unexpected groups: %v", groups)
+	}
+
+	request(t, s, "POST", "/components", `{"name":"API","group_id":5}`)
+	if component, ok := s.Component(1); !ok || component.GroupID != 5 || component.Status != 1 || !component.Enabled {
+		t.Errorf("expected an enabled operational component, got %+v", component)
+	}
+
+	request(t, s, "POST", "/metrics", `{"id":9,"name":"API latency","suffix":"ms","calc_type":1}`)
+	if metrics := s.Metrics(); len(metrics) != 2 || metrics[1].ID != 2 || metrics[1].Suffix != "ms" || metrics[1].CalcType != 1 {
+		t.Errorf("unexpected metrics: %v", metrics)
+	}
+
+	s.PerPage = 1
+	if _, response := request(t, s, "GET", "/metrics?per_page=100&page=2", ""); len(response["data"].([]interface{})) != 1 || response["data"].([]interface{})[0].(map[string]interface{})["name"] != "API latency" {
+		t.Errorf("expected the page size to be forced, got %v", response)
+	}
+}
+
+func TestServerFaults(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.AddComponent(Component{Name: "Website"})
+
+	s.Inject(Fault{Method: "GET", Path: "/components", Status: http.StatusInternalServerError, Times: 2})
+	s.Inject(Fault{Path: "/incidents", Malformed: true})
+	s.Inject(Fault{Path: "/ping", Latency: 50 * time.Millisecond})
+
+	for i := 0; i < 2; i++ {
+		if status, _ := request(t, s, "GET", "/components/1", ""); status != http.StatusInternalServerError {
+			t.Errorf("expected an injected 500, got %d", status)
+		}
+	}
+	if status, _ := request(t, s, "GET", "/components/1", ""); status != http.StatusOK {
+		t.Errorf("expected the fault to be used up, got %d", status)
+	}
+
+	if _, response := request(t, s, "GET", "/incidents", ""); response["raw"] != `{"data":{"id":` {
+		t.Errorf("expected a malformed body, got %v", response)
+	}
+
+	start := time.Now()
+	request(t, s, "GET", "/ping", "")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected an injected latency, got %v", elapsed)
+	}
+
+	s.ClearFaults()
+	if status, _ := request(t, s, "GET", "/incidents", ""); status != http.StatusOK {
+		t.Errorf("expected the faults to be cleared, got %d", status)
+	}
+}
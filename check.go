@@ -0,0 +1,141 @@
+package cachet
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// CheckResult is the outcome of a single check run by the check command
+type CheckResult struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Target  string `json:"target"`
+	Up      bool   `json:"up"`
+	Warning bool   `json:"warning"`
+	// not set for monitors without a response time of their own
+	LatencyMs  *int64                 `json:"latency_ms,omitempty"`
+	FailReason string                 `json:"fail_reason,omitempty"`
+	Details    map[string]interface{} `json:"details,omitempty"`
+	// metric points the check would have sent, by name
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+	// why the monitor cannot be checked once (heartbeats wait for pings)
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// RunChecks runs the test of the selected monitors (all when names is empty) once, without Cachet:
+// no component, incident, metric or shell hook. Composite monitors run after their members;
+// the members of a selected composite are run too, but only the selected monitors are reported.
+func (cfg *CachetMonitor) RunChecks(names []string) ([]CheckResult, error) {
+	selected := map[string]bool{}
+	for _, name := range names {
+		selected[name] = true
+	}
+
+	byName := map[string]MonitorInterface{}
+	monitors := []MonitorInterface{}
+	for _, monitor := range cfg.Monitors {
+		mon := monitor.GetMonitor()
+		mon.config = cfg
+		cfg.registerMonitor(mon)
+		byName[mon.Name] = monitor
+
+		if len(names) == 0 || selected[mon.Name] {
+			monitors = append(monitors, monitor)
+			delete(selected, mon.Name)
+		}
+	}
+
+	if len(selected) > 0 {
+		unknown := []string{}
+		for _, name := range names {
+			if selected[name] {
+				unknown = append(unknown, name)
+			}
+		}
+		return nil, errors.New("Unknown monitor(s): " + strings.Join(unknown, ", "))
+	}
+
+	// members run before their composites, once
+	checked := map[MonitorInterface]CheckResult{}
+	visiting := map[MonitorInterface]bool{}
+	var run func(monitor MonitorInterface) CheckResult
+	run = func(monitor MonitorInterface) CheckResult {
+		if result, ok := checked[monitor]; ok || visiting[monitor] {
+			return result
+		}
+		visiting[monitor] = true
+
+		if composite, ok := monitor.(*CompositeMonitor); ok {
+			for _, name := range composite.Members {
+				if member, ok := byName[name]; ok {
+					run(member)
+				}
+			}
+		}
+
+		checked[monitor] = cfg.RunCheck(monitor)
+		return checked[monitor]
+	}
+
+	results := make([]CheckResult, len(monitors))
+	for i, monitor := range monitors {
+		results[i] = run(monitor)
+	}
+
+	return results, nil
+}
+
+// RunCheck runs the test of a monitor once, its result is published for the composite monitors
+func (cfg *CachetMonitor) RunCheck(monitor MonitorInterface) CheckResult {
+	mon := monitor.GetMonitor()
+	result := CheckResult{Name: mon.Name, Type: mon.Type, Target: mon.Target}
+
+	if _, ok := monitor.(*HeartbeatMonitor); ok {
+		result.Up = true
+		result.Skipped = "heartbeat monitors wait for pings"
+		return result
+	}
+
+	l := logrus.WithFields(logrus.Fields{"monitor": mon.Name})
+
+	mon.config = cfg
+	mon.checkOnly = true
+	mon.customLag = -1
+	mon.noLag = false
+	mon.warning = false
+	mon.metricPoints = nil
+	mon.details = nil
+	mon.lastFailReason = ""
+
+	start := getMs()
+	result.Up = monitor.test(l)
+	lag := getMs() - start
+	if mon.customLag >= 0 {
+		lag = mon.customLag
+	}
+
+	if !mon.noLag {
+		result.LatencyMs = &lag
+	}
+	if !result.Up {
+		result.Warning = mon.warning
+		result.FailReason = mon.lastFailReason
+	}
+	result.Details = mon.details
+	for _, point := range mon.metricPoints {
+		if result.Metrics == nil {
+			result.Metrics = map[string]float64{}
+		}
+		result.Metrics[point.name] = point.value
+	}
+
+	mon.lastTick = time.Now()
+	mon.lastLag = lag
+	mon.pushHistory(result.Up, !result.Up && mon.warning)
+	mon.publishStatus(monitor)
+
+	return result
+}
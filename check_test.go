@@ -0,0 +1,81 @@
+package cachet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunChecks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write([]byte("status: ok"))
+	}))
+	defer srv.Close()
+
+	web := &HTTPMonitor{
+		AbstractMonitor:    AbstractMonitor{Name: "web", Target: srv.URL, ComponentID: 1, ShellHookOnSuccess: "false"},
+		ExpectedStatusCode: []int{200},
+		ExpectedBody:       "status: (ok|ko)",
+	}
+	api := &HTTPMonitor{
+		AbstractMonitor:    AbstractMonitor{Name: "api", Target: srv.URL + "/down", ComponentID: 2},
+		ExpectedStatusCode: []int{200},
+	}
+	global := &CompositeMonitor{
+		AbstractMonitor: AbstractMonitor{Name: "global", ComponentID: 3},
+		Members:         []string{"web", "api"},
+	}
+	beat := &HeartbeatMonitor{AbstractMonitor: AbstractMonitor{Name: "cron", ComponentID: 4}, Token: "s3cret"}
+
+	cfg := &CachetMonitor{Monitors: []MonitorInterface{global, web, api, beat}}
+	for _, monitor := range cfg.Monitors {
+		if errs := monitor.Validate(); len(errs) > 0 {
+			t.Fatalf("%s: %v", monitor.GetMonitor().Name, errs)
+		}
+	}
+
+	results, err := cfg.RunChecks(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected a result per monitor, got %v", results)
+	}
+
+	composite, up, down, skipped := results[0], results[1], results[2], results[3]
+	if !up.Up || up.LatencyMs == nil || up.Details["status_code"] != 200 || up.Details["matched_body"] != "status: (ok|ko)" {
+		t.Errorf("unexpected web result: %+v", up)
+	}
+	if down.Up || down.Details["status_code"] != http.StatusServiceUnavailable || len(down.FailReason) == 0 {
+		t.Errorf("unexpected api result: %+v", down)
+	}
+	if composite.Up || composite.LatencyMs != nil || !strings.Contains(composite.FailReason, "api") {
+		t.Errorf("expected the composite to run on the results of its members, got %+v", composite)
+	}
+	if !skipped.Up || len(skipped.Skipped) == 0 {
+		t.Errorf("expected the heartbeat to be skipped, got %+v", skipped)
+	}
+
+	// the members of a selected composite are run, but not reported
+	for _, mon := range []*HTTPMonitor{web, api} {
+		mon.history = nil
+		mon.warningHistory = nil
+	}
+	results, err = cfg.RunChecks([]string{"global"})
+	if err != nil || len(results) != 1 || results[0].Up || !strings.Contains(results[0].FailReason, "api") {
+		t.Errorf("expected the composite to fail on its members, got %+v (%v)", results, err)
+	}
+
+	results, err = cfg.RunChecks([]string{"api"})
+	if err != nil || len(results) != 1 || results[0].Name != "api" {
+		t.Errorf("expected the api result only, got %v (%v)", results, err)
+	}
+
+	if _, err := cfg.RunChecks([]string{"api", "db"}); err == nil || !strings.Contains(err.Error(), "db") {
+		t.Errorf("expected an unknown monitor error, got %v", err)
+	}
+}
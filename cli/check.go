@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"cachet"
+	"github.com/Sirupsen/logrus"
+)
+
+// check validates the configuration and runs the selected monitors once, without Cachet.
+// It returns whether every check passed (warnings pass).
+func check(arguments map[string]interface{}) (bool, error) {
+	level := logrus.WarnLevel
+	if loglevel := arguments["--log-level"]; loglevel != nil {
+		var err error
+		if level, err = logrus.ParseLevel(loglevel.(string)); err != nil {
+			return false, err
+		}
+	}
+	logrus.SetLevel(level)
+
+	cfg, err := readConfiguration(arguments)
+	if err != nil {
+		return false, err
+	}
+	// runs in CI without Cachet credentials
+	cfg.Offline = true
+	if valid := cfg.Validate(); !valid {
+		return false, errors.New("invalid configuration")
+	}
+
+	names := []string{}
+	if selected, ok := arguments["--monitor"].([]string); ok {
+		names = selected
+	}
+
+	results, err := cfg.RunChecks(names)
+	if err != nil {
+		return false, err
+	}
+
+	passed := true
+	for _, result := range results {
+		if !result.Up && !result.Warning {
+			passed = false
+		}
+	}
+
+	if asJSON, ok := arguments["--json"]; ok && asJSON.(bool) {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(map[string]interface{}{"passed": passed, "results": results})
+	} else {
+		err = writeCheckResults(os.Stdout, results)
+	}
+
+	return passed, err
+}
+
+// writeCheckResults outputs a line per check followed by its details
+func writeCheckResults(w io.Writer, results []cachet.CheckResult) error {
+	lines := []string{}
+	for _, result := range results {
+		state := "[ OK ]"
+		switch {
+		case len(result.Skipped) > 0:
+			state = "[SKIP]"
+		case result.Warning:
+			state = "[WARN]"
+		case !result.Up:
+			state = "[FAIL]"
+		}
+
+		line := state + " " + result.Name + " (" + result.Type + " " + result.Target + ")"
+		if result.LatencyMs != nil {
+			line += fmt.Sprintf(" %dms", *result.LatencyMs)
+		}
+		lines = append(lines, line)
+
+		if len(result.Skipped) > 0 {
+			lines = append(lines, "       "+result.Skipped)
+		}
+		if len(result.FailReason) > 0 {
+			lines = append(lines, "       "+strings.Replace(result.FailReason, "\n", "\n       ", -1))
+		}
+
+		keys := []string{}
+		for key := range result.Details {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			lines = append(lines, fmt.Sprintf("       %s: %v", key, result.Details[key]))
+		}
+
+		keys = []string{}
+		for key := range result.Metrics {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			lines = append(lines, fmt.Sprintf("       metric %s: %v", key, result.Metrics[key]))
+		}
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"cachet"
+	"github.com/Sirupsen/logrus"
+)
+
+// discover writes a configuration generated from the components of a Cachet instance
+func discover(arguments map[string]interface{}) error {
+	api := &cachet.CachetAPI{
+		URL:   os.Getenv("CACHET_API"),
+		Token: os.Getenv("CACHET_TOKEN"),
+	}
+	if apiURL := arguments["--api"]; apiURL != nil {
+		api.URL = apiURL.(string)
+	}
+	if token := arguments["--token"]; token != nil {
+		api.Token = token.(string)
+	}
+	if len(api.URL) == 0 || len(api.Token) == 0 {
+		return errors.New("API URL or API Token missing (--api and --token, or CACHET_API and CACHET_TOKEN)")
+	}
+
+	format := "yaml"
+	if f := arguments["--format"]; f != nil {
+		format = strings.ToLower(f.(string))
+	}
+	if format != "yaml" && format != "json" {
+		return errors.New("unsupported format '" + format + "' (yaml or json)")
+	}
+
+	discovery, err := cachet.Discover(context.Background(), api)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if path := arguments["--output"]; path != nil {
+		file, err := os.Create(path.(string))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if format == "json" {
+		err = discovery.WriteJSON(w)
+	} else {
+		err = discovery.WriteYAML(w)
+	}
+	if err != nil {
+		return err
+	}
+
+	unguessable := 0
+	for _, monitor := range discovery.Monitors {
+		if len(monitor.Unguessable) > 0 {
+			unguessable++
+		}
+	}
+	logrus.Infof("Discovered %d components (%d without a target) and %d unmatched metrics", len(discovery.Monitors), unguessable, len(discovery.Metrics))
+
+	return nil
+}
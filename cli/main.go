@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io/ioutil"
@@ -10,6 +11,8 @@ import (
 	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"cachet"
@@ -22,11 +25,17 @@ var AppBranch string
 var Build string
 var BuildDate string
 
+// how often the configuration file is checked for changes in --watch mode
+const configWatchInterval = 5 * time.Second
+
 const usage = `cachet-monitor
 
 Usage:
   cachet-monitor (-c PATH | --config PATH)
-  cachet-monitor (-c PATH | --config PATH) [--log=LOGPATH] [--name=NAME] [--immediate] [--config-test] [--log-level=LOGLEVEL]
+  cachet-monitor (-c PATH | --config PATH) [--log=LOGPATH] [--name=NAME] [--immediate] [--config-test] [--log-level=LOGLEVEL] [--watch] [--no-create] [--dry-run]
+  cachet-monitor check (-c PATH | --config PATH) [--monitor=NAME...] [--json] [--log-level=LOGLEVEL]
+  cachet-monitor simulate (-c PATH | --config PATH) --input=PATH [--monitor=NAME] [--json]
+  cachet-monitor discover [--api=URL] [--token=TOKEN] [--format=FORMAT] [--output=PATH]
   cachet-monitor -h | --help | --version
 
 Options:
@@ -37,18 +46,32 @@ Options:
   [--config-test]                Check configuration file
   [--version]                    Show version
   [--immediate]                  Tick immediately (by default waits for first defined interval)
+  [--watch]                      Reload the configuration when the file changes (SIGHUP always reloads)
+  [--no-create]                  Fail when a component or metric referenced by name is missing instead of creating it
+  [--dry-run]                    Log the writes to Cachet instead of sending them, and summarise them on exit
+  [--monitor]                    Name of a monitor to check (repeatable, defaults to all monitors)
+  [--json]                       Outputs the check or simulation results as JSON
+  [--input]                      Recorded check results to simulate, CSV or JSON lines (- for STDIN)
+  [--api] [--token]              Cachet API URL and token to discover (default to CACHET_API and CACHET_TOKEN)
+  [--format]                     Format of the discovered configuration: yaml (default) or json
+  [--output]                     Writes the discovered configuration to a file instead of STDOUT
 
 Arguments:
   PATH     path to config.json
   LOGLEVEL log level (debug, info, warn, error or fatal)
   LOGPATH  path to log output (defaults to STDOUT)
   NAME     name of this logger
+  URL      Cachet API URL
+  FORMAT   yaml or json
 
 Examples:
   cachet-monitor -c /root/cachet-monitor.json
   cachet-monitor -c /root/cachet-monitor.json --config-test
   cachet-monitor -c /root/cachet-monitor.json --log=/var/log/cachet-monitor.log --name="development machine"
   cachet-monitor -c /root/cachet-monitor.json --log=/var/log/cachet-monitor.log
+  cachet-monitor check -c /root/cachet-monitor.json --monitor=website --json
+  cachet-monitor simulate -c /root/cachet-monitor.json --monitor=website --input=website-checks.csv
+  cachet-monitor discover --api=https://status.example.com/api/v1 --token=TOKEN --output=/root/cachet-monitor.yml
 
 Environment variables:
   CACHET_API      override API url from configuration
@@ -61,19 +84,45 @@ func main() {
 		logrus.Panicf("Unable to start (reading config): %v", err)
 	}
 
-	logrus.SetOutput(getLogger(arguments["--log"]))
+	if command, ok := arguments["discover"]; ok && command.(bool) {
+		// the configuration may be written to STDOUT
+		logrus.SetOutput(os.Stderr)
+		if err := discover(arguments); err != nil {
+			logrus.Errorf("Discovery failed: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	cfg, err := getConfiguration(arguments["--config"].(string))
-	if err != nil {
-		logrus.Panicf("Unable to start (reading config): %v", err)
+	if command, ok := arguments["check"]; ok && command.(bool) {
+		// the results are written to STDOUT
+		logrus.SetOutput(os.Stderr)
+		passed, err := check(arguments)
+		if err != nil {
+			logrus.Errorf("Check failed: %v", err)
+			os.Exit(1)
+		}
+		if !passed {
+			os.Exit(1)
+		}
+		return
 	}
 
-	if immediate, ok := arguments["--immediate"]; ok {
-		cfg.Immediate = immediate.(bool)
+	if command, ok := arguments["simulate"]; ok && command.(bool) {
+		logrus.SetOutput(os.Stderr)
+		logrus.SetLevel(logrus.WarnLevel)
+		if err := simulate(arguments); err != nil {
+			logrus.Errorf("Simulation failed: %v", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	if name := arguments["--name"]; name != nil {
-		cfg.SystemName = name.(string)
+	logrus.SetOutput(getLogger(arguments["--log"]))
+
+	cfg, err := readConfiguration(arguments)
+	if err != nil {
+		logrus.Panicf("Unable to start (reading config): %v", err)
 	}
 
 	if loglevel := arguments["--log-level"]; loglevel != nil {
@@ -93,12 +142,6 @@ func main() {
 		}
 	}
 
-	if len(os.Getenv("CACHET_API")) > 0 {
-		cfg.API.URL = os.Getenv("CACHET_API")
-	}
-	if len(os.Getenv("CACHET_TOKEN")) > 0 {
-		cfg.API.Token = os.Getenv("CACHET_TOKEN")
-	}
 	if len(os.Getenv("CACHET_DEV")) > 0 {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
@@ -110,6 +153,17 @@ func main() {
 
 	if configtest, ok := arguments["--config-test"]; ok {
 		if configtest.(bool) {
+			if cfg.NoCreate {
+				// references to missing components and metrics are part of the validation
+				if err := cfg.API.Ping(context.Background()); err != nil {
+					logrus.Errorf("Cannot ping cachet!\n%v", err)
+					os.Exit(1)
+				}
+				if err := cfg.Provision(context.Background(), cfg.Monitors); err != nil {
+					logrus.Errorf("Invalid configuration\n%v", err)
+					os.Exit(1)
+				}
+			}
 			logrus.Infof("Configuration is valid!")
 			os.Exit(0)
 		}
@@ -118,38 +172,93 @@ func main() {
 	logrus.Debug("Configuration valid")
 	logrus.Infof("System: %s", cfg.SystemName)
 	logrus.Infof("API: %s", cfg.API.URL)
+	if cfg.DryRun {
+		logrus.Warnf("Dry run: writes to Cachet are logged, not sent")
+	}
 	logrus.Infof("Monitors: %d\n", len(cfg.Monitors))
 
+	if err := cfg.Queue.Open(&cfg.API); err != nil {
+		logrus.Errorf("Cannot open offline queue!\n%v", err)
+		os.Exit(1)
+	}
+
 	logrus.Infof("Pinging cachet")
-	if err := cfg.API.Ping(); err != nil {
+	if err := cfg.API.Ping(context.Background()); err != nil {
 		logrus.Errorf("Cannot ping cachet!\n%v", err)
 		os.Exit(1)
 	}
 	logrus.Infof("Ping OK")
 
+	if err := cfg.Provision(context.Background(), cfg.Monitors); err != nil {
+		logrus.Errorf("Invalid configuration\n%v", err)
+		os.Exit(1)
+	}
+
 	wg := &sync.WaitGroup{}
 	for index, monitor := range cfg.Monitors {
 		logrus.Infof("Starting Monitor #%d: ", index)
 		logrus.Infof("Features: \n - %v", strings.Join(monitor.Describe(), "\n - "))
 
 		if monitor.Init(cfg) {
-			go monitor.ClockStart(cfg, monitor, wg)
+			monitor.ClockStart(cfg, monitor, wg)
 			logrus.Infof("Monitor #%d has been started", index)
 		} else {
 			logrus.Errorf("Monitor #%d has been skipped", index)
 		}
 	}
 
+	if err := cfg.Heartbeat.Start(); err != nil {
+		logrus.Errorf("Cannot start heartbeat receiver!\n%v", err)
+		os.Exit(1)
+	}
+
+	if err := cfg.Admin.Start(cfg.Monitors); err != nil {
+		logrus.Errorf("Cannot start admin API!\n%v", err)
+		os.Exit(1)
+	}
+
+	if err := cfg.Peers.Start(); err != nil {
+		logrus.Errorf("Cannot start peer API!\n%v", err)
+		os.Exit(1)
+	}
+
+	reloads := make(chan bool, 1)
+	if watch, ok := arguments["--watch"]; ok && watch.(bool) {
+		go watchConfiguration(arguments["--config"].(string), reloads)
+	}
+
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, os.Kill)
-	<-signals
+	signal.Notify(signals, os.Interrupt, os.Kill, syscall.SIGHUP)
+
+loop:
+	for {
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				break loop
+			}
+			logrus.Infof("SIGHUP received: reloading configuration")
+			reloadConfiguration(cfg, arguments, wg)
+		case <-reloads:
+			logrus.Infof("Configuration file changed: reloading configuration")
+			reloadConfiguration(cfg, arguments, wg)
+		}
+	}
 
 	logrus.Warnf("Abort: Waiting monitors to finish")
 	for _, mon := range cfg.Monitors {
 		mon.GetMonitor().ClockStop()
 	}
+	cfg.Heartbeat.Stop()
+	cfg.Admin.Stop()
+	cfg.Peers.Stop()
 
 	wg.Wait()
+	cfg.Queue.Close()
+
+	if cfg.API.DryRun != nil {
+		logrus.Warnf("Dry run summary, nothing has been sent to Cachet:\n%s", strings.Join(cfg.API.DryRun.Summary(), "\n"))
+	}
 }
 
 func getLogger(logPath interface{}) *os.File {
@@ -166,6 +275,94 @@ func getLogger(logPath interface{}) *os.File {
 	return file
 }
 
+// readConfiguration reads the configuration and applies the command line and environment overrides
+func readConfiguration(arguments map[string]interface{}) (*cachet.CachetMonitor, error) {
+	cfg, err := getConfiguration(arguments["--config"].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	if immediate, ok := arguments["--immediate"]; ok {
+		cfg.Immediate = immediate.(bool)
+	}
+
+	if noCreate, ok := arguments["--no-create"]; ok {
+		cfg.NoCreate = noCreate.(bool)
+	}
+
+	if dryRun, ok := arguments["--dry-run"]; ok && dryRun.(bool) {
+		cfg.DryRun = true
+	}
+
+	if name := arguments["--name"]; name != nil {
+		cfg.SystemName = name.(string)
+	}
+
+	if len(os.Getenv("CACHET_API")) > 0 {
+		cfg.API.URL = os.Getenv("CACHET_API")
+	}
+	if len(os.Getenv("CACHET_TOKEN")) > 0 {
+		cfg.API.Token = os.Getenv("CACHET_TOKEN")
+	}
+
+	return cfg, nil
+}
+
+// reloadConfiguration applies the monitors of the new configuration, the running one is kept if it is invalid
+func reloadConfiguration(cfg *cachet.CachetMonitor, arguments map[string]interface{}, wg *sync.WaitGroup) {
+	newCfg, err := readConfiguration(arguments)
+	if err != nil {
+		logrus.Errorf("Reload aborted, keeping the running configuration: %v", err)
+		return
+	}
+
+	if valid := newCfg.Validate(); !valid {
+		logrus.Errorf("Reload aborted, keeping the running configuration: invalid configuration")
+		return
+	}
+
+	if changed := cfg.RestartRequired(newCfg); len(changed) > 0 {
+		logrus.Warnf("Changes to %s require a restart and have been ignored", strings.Join(changed, ", "))
+	}
+
+	if err := cfg.Provision(context.Background(), newCfg.Monitors); err != nil {
+		logrus.Errorf("Reload aborted, keeping the running configuration: %v", err)
+		return
+	}
+
+	cfg.ReloadMonitors(newCfg, wg)
+
+	if err := cfg.Heartbeat.Start(); err != nil {
+		logrus.Errorf("Cannot start heartbeat receiver!\n%v", err)
+	}
+
+	logrus.Infof("Configuration reloaded (monitors: %d)", len(cfg.Monitors))
+}
+
+// watchConfiguration requests a reload whenever the modification time of the configuration file changes
+func watchConfiguration(path string, reloads chan<- bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		logrus.Errorf("Cannot watch configuration file: %v", err)
+		return
+	}
+	modTime := info.ModTime()
+
+	for range time.Tick(configWatchInterval) {
+		info, err := os.Stat(path)
+		if err != nil || info.ModTime().Equal(modTime) {
+			continue
+		}
+		modTime = info.ModTime()
+
+		select {
+		case reloads <- true:
+		default:
+			// a reload is already pending
+		}
+	}
+}
+
 func getConfiguration(path string) (*cachet.CachetMonitor, error) {
 	var cfg cachet.CachetMonitor
 	var data []byte
@@ -212,18 +409,48 @@ func getConfiguration(path string) (*cachet.CachetMonitor, error) {
 			monType = cachet.GetMonitorType(t)
 		}
 
+		settings, metricRefs, err := cachet.SplitMetricRefs(rawMonitor)
+		if err != nil {
+			logrus.Errorf("Unable to unmarshal monitor metrics (index: %d): %v", index, err)
+			continue
+		}
+
 		switch monType {
 			case "http":
 				var s cachet.HTTPMonitor
-				err = mapstructure.Decode(rawMonitor, &s)
+				err = mapstructure.Decode(settings, &s)
 				t = &s
 			case "dns":
 				var s cachet.DNSMonitor
-				err = mapstructure.Decode(rawMonitor, &s)
+				err = mapstructure.Decode(settings, &s)
+				t = &s
+			case "tcp":
+				var s cachet.TCPMonitor
+				err = mapstructure.Decode(settings, &s)
+				t = &s
+			case "icmp":
+				var s cachet.ICMPMonitor
+				err = mapstructure.Decode(settings, &s)
+				t = &s
+			case "tls":
+				var s cachet.TLSMonitor
+				err = mapstructure.Decode(settings, &s)
+				t = &s
+			case "heartbeat":
+				var s cachet.HeartbeatMonitor
+				err = mapstructure.Decode(settings, &s)
+				t = &s
+			case "exec":
+				var s cachet.ExecMonitor
+				err = mapstructure.Decode(settings, &s)
+				t = &s
+			case "composite":
+				var s cachet.CompositeMonitor
+				err = mapstructure.Decode(settings, &s)
 				t = &s
 			case "mock":
 				var s cachet.MockMonitor
-				err = mapstructure.Decode(rawMonitor, &s)
+				err = mapstructure.Decode(settings, &s)
 				t = &s
 			default:
 				logrus.Errorf("Invalid monitor type (index: %d) %v", index, monType)
@@ -231,6 +458,7 @@ func getConfiguration(path string) (*cachet.CachetMonitor, error) {
 		}
 
 		t.GetMonitor().Type = monType
+		t.GetMonitor().MetricRefs = metricRefs
 
 		if err != nil {
 			logrus.Errorf("Unable to unmarshal monitor to type (index: %d): %v", index, err)
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"cachet"
+)
+
+// simulate replays recorded check results through a monitor and writes the timeline of what it would have done
+func simulate(arguments map[string]interface{}) error {
+	cfg, err := readConfiguration(arguments)
+	if err != nil {
+		return err
+	}
+
+	name := ""
+	switch monitor := arguments["--monitor"].(type) {
+	case string:
+		name = monitor
+	case []string:
+		if len(monitor) > 1 {
+			return errors.New("a single monitor can be simulated at once")
+		}
+		if len(monitor) == 1 {
+			name = monitor[0]
+		}
+	}
+
+	var input io.Reader = os.Stdin
+	if path := arguments["--input"].(string); path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	checks, err := cachet.ReadSimulatedChecks(input)
+	if err != nil {
+		return errors.New("cannot read check results: " + err.Error())
+	}
+
+	simulation, err := cfg.Simulate(name, checks)
+	if err != nil {
+		return err
+	}
+
+	if asJSON, ok := arguments["--json"]; ok && asJSON.(bool) {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(simulation)
+	}
+
+	lines := []string{fmt.Sprintf("%s: %d checks, %d failures, %d incident(s), not operational for %v",
+		simulation.Monitor, simulation.Checks, simulation.Failures, simulation.Incidents, time.Duration(simulation.NotOperationalSeconds)*time.Second)}
+	for _, event := range simulation.Events {
+		lines = append(lines, event.Time.Format(time.RFC3339)+"  "+event.String())
+	}
+
+	_, err = io.WriteString(os.Stdout, strings.Join(lines, "\n")+"\n")
+
+	return err
+}
@@ -1,10 +1,11 @@
 package cachet
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"net/url"
 	"strconv"
-
-	"github.com/Sirupsen/logrus"
 )
 
 // Component Cachet data model
@@ -13,36 +14,38 @@ type Component struct {
 	Name   string `json:"name"`
 	Status int `json:"status"`
 	Enabled bool `json:"enabled"`
+	GroupID int `json:"group_id"`
+	Description string `json:"description"`
+	Link string `json:"link"`
 }
 
-// LoadCurrentIncident - Returns current incident
-func (comp *Component) LoadCurrentIncident(cfg *CachetMonitor) (*Incident, error) {
+// LoadCurrentIncident - Returns current (unresolved) incident
+func (comp *Component) LoadCurrentIncident(ctx context.Context, cfg *CachetMonitor) (*Incident, error) {
 	if comp.ID == 0 {
 		return nil, nil
 	}
 
-	jsonBytes, _ := json.Marshal(map[string]interface{}{
-		"component_id":	strconv.Itoa(comp.ID),
-		"status":	1,
-		"per_page":	1,
-	})
-
-	resp, body, err := cfg.API.NewRequest("GET", "/incidents", jsonBytes)
+	// latest incident of the component, unresolved when not yet fixed
+	query := url.Values{}
+	query.Set("component_id", strconv.Itoa(comp.ID))
+	query.Set("sort", "id")
+	query.Set("order", "desc")
+	query.Set("per_page", "1")
 
-	if err != nil || resp.StatusCode != 200 {
-		logrus.Warnf("Could not get data from component (id: %d, status: %d, err: %v)", comp.ID, resp.StatusCode, err)
+	_, body, err := cfg.API.NewRequest(ctx, "GET", "/incidents?"+query.Encode(), nil)
+	if err != nil {
 		return nil, err
 	}
 
 	incidentInfoA := []Incident{}
 
-	if e := json.Unmarshal(body.Data, &incidentInfoA); e != nil {
-	        logrus.Warnf("Error decoding JSON: %v\n", e)
+	if err := json.Unmarshal(body.Data, &incidentInfoA); err != nil {
+		return nil, fmt.Errorf("Cannot parse incidents of component %d: %v", comp.ID, err)
 	}
 
-	if len(incidentInfoA) == 0 {
-		return nil, err
+	if len(incidentInfoA) == 0 || incidentInfoA[0].Status >= 4 {
+		return nil, nil
 	}
 
-	return &incidentInfoA[0], err
-}
\ No newline at end of file
+	return &incidentInfoA[0], nil
+}
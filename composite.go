@@ -0,0 +1,225 @@
+package cachet
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Investigating template
+var defaultCompositeInvestigatingTpl = MessageTemplate{
+	Subject: `{{ .Monitor.Name }} - {{ .SystemName }}`,
+	Message: `{{ .Monitor.Name }} is **degraded** (server time: {{ .now }})
+
+{{ .FailReason }}`,
+}
+
+// CompositeMonitor derives its state from the last check of other monitors
+type CompositeMonitor struct {
+	AbstractMonitor `mapstructure:",squash"`
+
+	// names of the member monitors
+	Members []string
+	// all (default), any, at_least <count> or weighted <percent>
+	Expression string
+	// member name => weight, for the weighted expression (defaults to 1)
+	Weights map[string]int
+
+	rule      string
+	ruleValue int
+}
+
+// parseCompositeExpression returns the rule and its argument
+func parseCompositeExpression(expr string) (string, int, error) {
+	fields := strings.Fields(strings.ToLower(expr))
+	if len(fields) == 0 {
+		return "all", 0, nil
+	}
+
+	switch fields[0] {
+	case "all", "any":
+		if len(fields) == 1 {
+			return fields[0], 0, nil
+		}
+	case "at_least", "weighted":
+		if len(fields) == 2 {
+			value, err := strconv.Atoi(fields[1])
+			if err != nil || value < 1 || (fields[0] == "weighted" && value > 100) {
+				return "", 0, errors.New("invalid value in composite expression '" + expr + "'")
+			}
+			return fields[0], value, nil
+		}
+	}
+
+	return "", 0, errors.New("unsupported composite expression '" + expr + "' (all, any, at_least <count> or weighted <percent>)")
+}
+
+func (monitor *CompositeMonitor) weight(member string) int {
+	if weight, ok := monitor.Weights[member]; ok {
+		return weight
+	}
+
+	return 1
+}
+
+func (monitor *CompositeMonitor) test(l *logrus.Entry) bool {
+	// no response time of its own
+	monitor.noLag = true
+
+	// members not running (e.g. their component could not be loaded) or not checked yet are unknown:
+	// they are left out of the count
+	up, known, upWeight, totalWeight := 0, 0, 0, 0
+	failing := []string{}
+	unknown := []string{}
+	for _, name := range monitor.Members {
+		member := monitor.config.findMonitor(name)
+		if member == nil {
+			unknown = append(unknown, name+" (not running)")
+			continue
+		}
+		status := member.Status()
+		if len(status.History) == 0 {
+			unknown = append(unknown, name+" (not checked yet)")
+			continue
+		}
+
+		known++
+		totalWeight += monitor.weight(name)
+		if status.History[len(status.History)-1] {
+			up++
+			upWeight += monitor.weight(name)
+			continue
+		}
+
+		if len(status.LastFailReason) > 0 {
+			failing = append(failing, name+" ("+status.LastFailReason+")")
+		} else {
+			failing = append(failing, name)
+		}
+	}
+
+	isUp := true
+	switch {
+	case known == 0:
+		l.Debugf("Composite %s: no member state known yet", monitor.Expression)
+	case monitor.rule == "all":
+		isUp = up == known
+	case monitor.rule == "any":
+		isUp = up > 0
+	case monitor.rule == "at_least":
+		// with unknown members, all the known ones may be enough
+		needed := monitor.ruleValue
+		if needed > known {
+			needed = known
+		}
+		isUp = up >= needed
+	case monitor.rule == "weighted":
+		isUp = totalWeight == 0 || upWeight*100 >= monitor.ruleValue*totalWeight
+	}
+	l.Debugf("Composite %s: %d/%d known members up (weight %d/%d), %d unknown", monitor.Expression, up, known, upWeight, totalWeight, len(unknown))
+	monitor.setDetail("members_up", strconv.Itoa(up)+"/"+strconv.Itoa(known))
+	if len(unknown) > 0 {
+		monitor.setDetail("members_unknown", strings.Join(unknown, ", "))
+		l.Infof("Composite members unknown: %s", strings.Join(unknown, ", "))
+	}
+
+	if !isUp {
+		monitor.lastFailReason = strconv.Itoa(len(failing)) + "/" + strconv.Itoa(known) + " members failing: " + strings.Join(failing, ", ")
+		if len(unknown) > 0 {
+			monitor.lastFailReason += "\nUnknown: " + strings.Join(unknown, ", ")
+		}
+		l.Infof("Composite failure: %s", monitor.lastFailReason)
+		return false
+	}
+
+	monitor.triggerShellHook(l, "on_success", monitor.ShellHookOnSuccess, "")
+
+	return true
+}
+
+func (mon *CompositeMonitor) Validate() []string {
+	mon.Template.Investigating.SetDefault(defaultCompositeInvestigatingTpl)
+	mon.Template.Fixed.SetDefault(defaultHTTPFixedTpl)
+
+	errs := mon.AbstractMonitor.Validate()
+
+	if len(mon.Members) == 0 {
+		errs = append(errs, "'Members' has not been set")
+	} else if len(mon.Target) == 0 {
+		mon.Target = strings.Join(mon.Members, ", ")
+	}
+
+	var err error
+	if mon.rule, mon.ruleValue, err = parseCompositeExpression(mon.Expression); err != nil {
+		errs = append(errs, err.Error())
+	} else if mon.rule == "at_least" && mon.ruleValue > len(mon.Members) {
+		errs = append(errs, "'at_least "+strconv.Itoa(mon.ruleValue)+"' is more than the number of members")
+	}
+	if len(mon.Expression) == 0 {
+		mon.Expression = mon.rule
+	}
+
+	for name, weight := range mon.Weights {
+		if weight < 0 {
+			errs = append(errs, "Negative weight for member "+name)
+		}
+	}
+
+	return errs
+}
+
+func (mon *CompositeMonitor) Describe() []string {
+	features := mon.AbstractMonitor.Describe()
+	features = append(features, "Members: "+strings.Join(mon.Members, ", "))
+	features = append(features, "Expression: "+mon.Expression)
+
+	return features
+}
+
+// validateComposites checks that composite members are existing monitors, without cycles between composites
+func (cfg *CachetMonitor) validateComposites() []string {
+	errs := []string{}
+
+	names := []string{}
+	known := map[string]bool{}
+	members := map[string][]string{}
+	for _, monitor := range cfg.Monitors {
+		if monitor != nil {
+			names = append(names, monitor.GetMonitor().Name)
+			known[monitor.GetMonitor().Name] = true
+		}
+	}
+
+	for _, monitor := range cfg.Monitors {
+		composite, ok := monitor.(*CompositeMonitor)
+		if !ok {
+			continue
+		}
+		for _, member := range composite.Members {
+			if member == composite.Name {
+				errs = append(errs, "Composite monitor "+composite.Name+" cannot be its own member")
+			} else if !known[member] {
+				errs = append(errs, "Composite monitor "+composite.Name+" has unknown member "+member)
+			} else {
+				members[composite.Name] = append(members[composite.Name], member)
+			}
+		}
+		for member := range composite.Weights {
+			found := false
+			for _, name := range composite.Members {
+				found = found || name == member
+			}
+			if !found {
+				errs = append(errs, "Composite monitor "+composite.Name+" has a weight for "+member+" which is not a member")
+			}
+		}
+	}
+
+	for _, cycle := range findCycles(names, members) {
+		errs = append(errs, "Composite cycle: "+strings.Join(cycle, " -> "))
+	}
+
+	return errs
+}
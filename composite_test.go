@@ -0,0 +1,148 @@
+package cachet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestParseCompositeExpression(t *testing.T) {
+	tests := map[string]string{
+		"":            "all",
+		"any":         "any",
+		"AT_LEAST 2":  "at_least",
+		"weighted 50": "weighted",
+	}
+	for expr, rule := range tests {
+		if got, _, err := parseCompositeExpression(expr); err != nil || got != rule {
+			t.Errorf("%q: expected %s, got %s (%v)", expr, rule, got, err)
+		}
+	}
+
+	for _, expr := range []string{"most", "at_least", "at_least two", "weighted 150", "any 2"} {
+		if _, _, err := parseCompositeExpression(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestCompositeMonitor(t *testing.T) {
+	l := logrus.WithFields(logrus.Fields{})
+	cfg := &CachetMonitor{}
+	members := map[string]*MockMonitor{}
+	for _, name := range []string{"eu", "us", "asia"} {
+		member := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: name, history: []bool{true}}}
+		member.config = cfg
+		cfg.registerMonitor(&member.AbstractMonitor)
+		member.publishStatus(member)
+		members[name] = member
+	}
+
+	composite := &CompositeMonitor{
+		AbstractMonitor: AbstractMonitor{Name: "global", config: cfg},
+		Members:         []string{"eu", "us", "asia"},
+		Weights:         map[string]int{"eu": 2},
+	}
+
+	members["us"].history = []bool{false}
+	members["us"].lastFailReason = "timeout"
+	members["us"].publishStatus(members["us"])
+
+	tests := map[string]bool{
+		"all":         false,
+		"any":         true,
+		"at_least 2":  true,
+		"at_least 3":  false,
+		"weighted 75": true,
+		"weighted 80": false,
+	}
+	for expr, expected := range tests {
+		composite.Expression = expr
+		composite.rule, composite.ruleValue, _ = parseCompositeExpression(expr)
+		composite.lastFailReason = ""
+		if up := composite.test(l); up != expected {
+			t.Errorf("%s: expected %v, got %v", expr, expected, up)
+		}
+		if !expected && !strings.Contains(composite.lastFailReason, "us (timeout)") {
+			t.Errorf("%s: expected the failing member in the reason, got %q", expr, composite.lastFailReason)
+		}
+	}
+}
+
+func TestCompositeMonitorUnknownMembers(t *testing.T) {
+	l := logrus.WithFields(logrus.Fields{})
+	cfg := &CachetMonitor{}
+	for name, history := range map[string][]bool{"eu": {true}, "us": {false}, "asia": {true}, "fresh": nil} {
+		member := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: name, history: history}}
+		member.config = cfg
+		cfg.registerMonitor(&member.AbstractMonitor)
+		member.publishStatus(member)
+	}
+
+	// "gone" is not running (its Init failed), "fresh" has not been checked yet
+	composite := &CompositeMonitor{
+		AbstractMonitor: AbstractMonitor{Name: "global", config: cfg},
+		Members:         []string{"eu", "us", "asia", "gone", "fresh"},
+		Weights:         map[string]int{"eu": 2, "gone": 10},
+	}
+
+	tests := map[string]bool{
+		"all":        false,
+		"any":        true,
+		"at_least 2": true,
+		// only 3 members are known
+		"at_least 4":  false,
+		"weighted 75": true,
+		"weighted 80": false,
+	}
+	for expr, expected := range tests {
+		composite.Expression = expr
+		composite.rule, composite.ruleValue, _ = parseCompositeExpression(expr)
+		composite.lastFailReason = ""
+		if up := composite.test(l); up != expected {
+			t.Errorf("%s: expected %v, got %v", expr, expected, up)
+		}
+		if !expected && !strings.HasSuffix(composite.lastFailReason, "Unknown: gone (not running), fresh (not checked yet)") {
+			t.Errorf("%s: expected the unknown members in the reason, got %q", expr, composite.lastFailReason)
+		}
+	}
+	if composite.details["members_up"] != "2/3" {
+		t.Errorf("expected 2/3 known members up, got %v", composite.details["members_up"])
+	}
+
+	// nothing known: nothing to report
+	composite.Members = []string{"gone", "fresh"}
+	composite.rule, composite.ruleValue, _ = parseCompositeExpression("all")
+	if !composite.test(l) {
+		t.Errorf("expected a composite without known member to stay up, got %q", composite.lastFailReason)
+	}
+}
+
+func TestValidateComposites(t *testing.T) {
+	cfg := &CachetMonitor{Monitors: []MonitorInterface{
+		&MockMonitor{AbstractMonitor: AbstractMonitor{Name: "eu"}},
+		&CompositeMonitor{AbstractMonitor: AbstractMonitor{Name: "global"}, Members: []string{"eu"}},
+	}}
+	if errs := cfg.validateComposites(); len(errs) > 0 {
+		t.Errorf("expected valid composites, got %v", errs)
+	}
+
+	cfg.Monitors = append(cfg.Monitors, &CompositeMonitor{
+		AbstractMonitor: AbstractMonitor{Name: "broken"},
+		Members:         []string{"broken", "unknown"},
+		Weights:         map[string]int{"eu": 2},
+	})
+	if errs := cfg.validateComposites(); len(errs) != 3 {
+		t.Errorf("expected 3 errors, got %v", errs)
+	}
+
+	cfg.Monitors = []MonitorInterface{
+		&MockMonitor{AbstractMonitor: AbstractMonitor{Name: "eu"}},
+		&CompositeMonitor{AbstractMonitor: AbstractMonitor{Name: "europe"}, Members: []string{"eu", "global"}},
+		&CompositeMonitor{AbstractMonitor: AbstractMonitor{Name: "global"}, Members: []string{"europe"}},
+	}
+	if errs := cfg.validateComposites(); len(errs) != 1 || errs[0] != "Composite cycle: europe -> global -> europe" {
+		t.Errorf("expected the cycle between composites, got %v", errs)
+	}
+}
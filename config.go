@@ -13,10 +13,30 @@ type CachetMonitor struct {
 	SystemName  string                   `json:"system_name" yaml:"system_name"`
 	DateFormat  string                   `json:"date_format" yaml:"date_format"`
 	API         CachetAPI                `json:"api"`
+	Heartbeat   HeartbeatServer          `json:"heartbeat" yaml:"heartbeat"`
+	Queue       OfflineQueue             `json:"queue" yaml:"queue"`
+	State       StateConfig              `json:"state" yaml:"state"`
+	Admin       AdminServer              `json:"admin" yaml:"admin"`
+	Peers       PeerGroup                `json:"peers" yaml:"peers"`
 	RawMonitors []map[string]interface{} `json:"monitors" yaml:"monitors"`
 
 	Monitors  []MonitorInterface `json:"-" yaml:"-"`
 	Immediate bool               `json:"-" yaml:"-"`
+	// only resolve the components and metrics referenced by name, never create them
+	NoCreate bool `json:"-" yaml:"-"`
+	// log the writes to Cachet instead of sending them
+	DryRun bool `json:"dry_run" yaml:"dry_run"`
+	// the monitors are run without Cachet (check command): the API settings are not required
+	Offline bool `json:"-" yaml:"-"`
+	// defaults to a JSON file store when state.file is set
+	StateStore StateStore `json:"-" yaml:"-"`
+
+	// initialised monitors by name, for dependencies
+	registry monitorRegistry
+	// IDs of the components and metrics referenced by name
+	provisioned provisionCache
+	// replaces time.Now in the incident logic (simulations)
+	clock func() time.Time
 }
 
 // Validate configuration
@@ -32,7 +52,21 @@ func (cfg *CachetMonitor) Validate() bool {
 		cfg.DateFormat = DefaultTimeFormat
 	}
 
-	if len(cfg.API.Token) == 0 || len(cfg.API.URL) == 0 {
+	if cfg.DryRun && cfg.API.DryRun == nil {
+		cfg.API.DryRun = &DryRunRecorder{}
+	}
+
+	// the state of a dry run is not saved
+	if cfg.StateStore == nil && len(cfg.State.File) > 0 && !cfg.DryRun {
+		cfg.StateStore = &JSONStateStore{Path: cfg.State.File}
+	}
+
+	if errs := cfg.Peers.Validate(cfg.SystemName); len(errs) > 0 {
+		logrus.Warnf("Peers validation errors: %v", "\n - "+strings.Join(errs, "\n - "))
+		valid = false
+	}
+
+	if !cfg.Offline && (len(cfg.API.Token) == 0 || len(cfg.API.URL) == 0) {
 		logrus.Warnf("API URL or API Token missing.\nGet help at https://github.com/castawaylabs/cachet-monitor")
 		valid = false
 	}
@@ -54,6 +88,16 @@ func (cfg *CachetMonitor) Validate() bool {
 		}
 	}
 
+	if errs := cfg.validateDependencies(); len(errs) > 0 {
+		logrus.Warnf("Monitor dependency errors: %v", "\n - "+strings.Join(errs, "\n - "))
+		valid = false
+	}
+
+	if errs := cfg.validateComposites(); len(errs) > 0 {
+		logrus.Warnf("Composite monitor errors: %v", "\n - "+strings.Join(errs, "\n - "))
+		valid = false
+	}
+
 	return valid
 }
 
@@ -72,6 +116,15 @@ func getHostname() string {
 	return addrs[0].String()
 }
 
+// now returns the current time, or the simulated one
+func (cfg *CachetMonitor) now() time.Time {
+	if cfg == nil || cfg.clock == nil {
+		return time.Now()
+	}
+
+	return cfg.clock()
+}
+
 func getMs() int64 {
 	return time.Now().UnixNano() / int64(time.Millisecond)
 }
@@ -87,8 +140,8 @@ func GetMonitorType(t string) string {
 func getTemplateData(monitor *AbstractMonitor) map[string]interface{} {
 	return map[string]interface{}{
 		"SystemName": monitor.config.SystemName,
-		"API":        monitor.config.API,
+		"API":        &monitor.config.API,
 		"Monitor":    monitor,
-		"now":        time.Now().Format(monitor.config.DateFormat),
+		"now":        monitor.config.now().Format(monitor.config.DateFormat),
 	}
 }
@@ -13,3 +13,19 @@ func TestGetMonitorType(t *testing.T) {
 		t.Error("does not return correct monitor type")
 	}
 }
+
+func TestValidateOffline(t *testing.T) {
+	newConfig := func() *CachetMonitor {
+		return &CachetMonitor{Monitors: []MonitorInterface{&MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", ComponentID: 1}}}}
+	}
+
+	if newConfig().Validate() {
+		t.Error("expected the API URL and token to be required")
+	}
+
+	cfg := newConfig()
+	cfg.Offline = true
+	if !cfg.Validate() {
+		t.Error("expected an offline configuration to be valid without API settings")
+	}
+}
@@ -0,0 +1,180 @@
+package cachet
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Dependency template
+var defaultDependencyTpl = MessageTemplate{
+	Subject: `{{ .Monitor.Name }} - {{ .SystemName }}`,
+	Message: `{{ .Monitor.Name }} is also affected by this incident (server time: {{ .now }})
+
+{{ .FailReason }}`,
+}
+
+// monitorRegistry indexes the initialised monitors by name
+type monitorRegistry struct {
+	mu       sync.Mutex
+	monitors map[string]*AbstractMonitor
+}
+
+func (cfg *CachetMonitor) registerMonitor(mon *AbstractMonitor) {
+	cfg.registry.mu.Lock()
+	defer cfg.registry.mu.Unlock()
+
+	if cfg.registry.monitors == nil {
+		cfg.registry.monitors = map[string]*AbstractMonitor{}
+	}
+	cfg.registry.monitors[mon.Name] = mon
+}
+
+func (cfg *CachetMonitor) unregisterMonitor(mon *AbstractMonitor) {
+	cfg.registry.mu.Lock()
+	defer cfg.registry.mu.Unlock()
+
+	if cfg.registry.monitors[mon.Name] == mon {
+		delete(cfg.registry.monitors, mon.Name)
+	}
+}
+
+func (cfg *CachetMonitor) findMonitor(name string) *AbstractMonitor {
+	cfg.registry.mu.Lock()
+	defer cfg.registry.mu.Unlock()
+
+	return cfg.registry.monitors[name]
+}
+
+// validateDependencies checks that depends_on references existing monitors without cycles
+func (cfg *CachetMonitor) validateDependencies() []string {
+	errs := []string{}
+
+	monitors := map[string]*AbstractMonitor{}
+	duplicates := map[string]bool{}
+	for _, monitor := range cfg.Monitors {
+		if monitor == nil {
+			continue
+		}
+		mon := monitor.GetMonitor()
+		if _, ok := monitors[mon.Name]; ok {
+			duplicates[mon.Name] = true
+		}
+		monitors[mon.Name] = mon
+	}
+
+	for _, mon := range monitors {
+		for _, parent := range mon.DependsOn {
+			if _, ok := monitors[parent]; !ok {
+				errs = append(errs, "Monitor "+mon.Name+" depends on unknown monitor "+parent)
+			} else if duplicates[parent] {
+				errs = append(errs, "Monitor "+mon.Name+" depends on "+parent+" which is not a unique name")
+			}
+		}
+	}
+
+	names := []string{}
+	parents := map[string][]string{}
+	for _, monitor := range cfg.Monitors {
+		if monitor != nil {
+			mon := monitor.GetMonitor()
+			names = append(names, mon.Name)
+			parents[mon.Name] = mon.DependsOn
+		}
+	}
+	for _, cycle := range findCycles(names, parents) {
+		errs = append(errs, "Dependency cycle: "+strings.Join(cycle, " -> "))
+	}
+
+	return errs
+}
+
+// findCycles returns the cycles of a graph (name => names it points to) found by a depth-first search
+// from each of the names in turn, as paths ending on their first name
+func findCycles(names []string, edges map[string][]string) [][]string {
+	cycles := [][]string{}
+
+	// a name met again while still on the path closes a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var visit func(name string, path []string)
+	visit = func(name string, path []string) {
+		if state[name] == visited {
+			return
+		}
+		path = append(path, name)
+		if state[name] == visiting {
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			cycles = append(cycles, path[start:])
+			return
+		}
+
+		state[name] = visiting
+		for _, next := range edges[name] {
+			visit(next, path)
+		}
+		state[name] = visited
+	}
+	for _, name := range names {
+		visit(name, nil)
+	}
+
+	return cycles
+}
+
+// parentIncident returns the first parent in an outage incident
+func (mon *AbstractMonitor) parentIncident() (string, MonitorStatus) {
+	for _, name := range mon.DependsOn {
+		parent := mon.config.findMonitor(name)
+		if parent == nil {
+			continue
+		}
+
+		status := parent.Status()
+		if status.IncidentID > 0 && !status.PerformanceIncident {
+			return name, status
+		}
+	}
+
+	return "", MonitorStatus{}
+}
+
+// suppressedByParent returns true when a parent incident already covers this outage,
+// optionally noting on the parent incident that this monitor is affected too
+func (mon *AbstractMonitor) suppressedByParent(l *logrus.Entry) bool {
+	if len(mon.DependsOn) == 0 {
+		return false
+	}
+
+	name, parent := mon.parentIncident()
+	if len(name) == 0 {
+		return false
+	}
+
+	l.Infof("not creating an incident: parent monitor %s is in incident %d", name, parent.IncidentID)
+	if !mon.DependencyNote || mon.notedParentIncident == parent.IncidentID {
+		return true
+	}
+
+	tplData := getTemplateData(mon)
+	tplData["FailReason"] = mon.lastFailReason
+	tplData["Parent"] = name
+
+	_, message := mon.Template.Dependency.Exec(tplData)
+	incident := &Incident{ID: parent.IncidentID, Status: parent.IncidentStatus}
+	if err := incident.PostUpdate(mon.apiContext(), mon.config, message); err != nil {
+		l.Warnf("Could not add a note to incident %d: %v", parent.IncidentID, err)
+	} else {
+		mon.notedParentIncident = parent.IncidentID
+	}
+
+	return true
+}
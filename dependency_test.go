@@ -0,0 +1,68 @@
+package cachet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func dependencyConfig(dependencies map[string][]string, names ...string) *CachetMonitor {
+	cfg := &CachetMonitor{}
+	for _, name := range names {
+		cfg.Monitors = append(cfg.Monitors, &MockMonitor{AbstractMonitor: AbstractMonitor{Name: name, DependsOn: dependencies[name]}})
+	}
+
+	return cfg
+}
+
+func TestValidateDependencies(t *testing.T) {
+	cfg := dependencyConfig(map[string][]string{"web": {"router"}, "api": {"router", "db"}}, "router", "db", "web", "api")
+	if errs := cfg.validateDependencies(); len(errs) > 0 {
+		t.Errorf("expected valid dependencies, got %v", errs)
+	}
+
+	cfg = dependencyConfig(map[string][]string{"web": {"unknown"}}, "web")
+	if errs := cfg.validateDependencies(); len(errs) != 1 {
+		t.Errorf("expected an unknown dependency error, got %v", errs)
+	}
+
+	cfg = dependencyConfig(map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}}, "a", "b", "c")
+	errs := cfg.validateDependencies()
+	if len(errs) != 1 || !strings.Contains(errs[0], "a -> b -> c -> a") {
+		t.Errorf("expected a cycle error, got %v", errs)
+	}
+
+	cfg = dependencyConfig(map[string][]string{"a": {"a"}}, "a")
+	if errs := cfg.validateDependencies(); len(errs) != 1 {
+		t.Errorf("expected a self dependency to be a cycle, got %v", errs)
+	}
+}
+
+func TestSuppressedByParent(t *testing.T) {
+	l := logrus.WithFields(logrus.Fields{})
+	cfg := &CachetMonitor{}
+	parent := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "router"}}
+	child := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", DependsOn: []string{"router"}}}
+	for _, mon := range []*MockMonitor{parent, child} {
+		mon.config = cfg
+		cfg.registerMonitor(&mon.AbstractMonitor)
+	}
+
+	parent.publishStatus(parent)
+	if child.suppressedByParent(l) {
+		t.Error("child should not be suppressed while its parent is up")
+	}
+
+	parent.incident = &Incident{ID: 12, Status: 1}
+	parent.publishStatus(parent)
+	if !child.suppressedByParent(l) {
+		t.Error("child should be suppressed while its parent is in incident")
+	}
+
+	parent.incidentIsPerformance = true
+	parent.publishStatus(parent)
+	if child.suppressedByParent(l) {
+		t.Error("child should not be suppressed by a performance incident")
+	}
+}
@@ -0,0 +1,350 @@
+package cachet
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// page size used to list Cachet resources
+const discoverPageSize = 100
+
+// check settings of the discovered monitors (seconds)
+const (
+	discoverInterval = 60
+	discoverTimeout  = 10
+)
+
+// words of the metric names and suffixes used for response times
+var latencyMetricWords = map[string]bool{
+	"latency":      true,
+	"response":     true,
+	"rtt":          true,
+	"ping":         true,
+	"lag":          true,
+	"ms":           true,
+	"milliseconds": true,
+}
+
+var hostnameRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?(\.[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?)+$`)
+
+// DiscoveredMonitor is a monitor guessed from a Cachet component
+type DiscoveredMonitor struct {
+	Name        string
+	Group       string
+	Type        string
+	Target      string
+	ComponentID int
+	// response time metrics, matched by name
+	ResponseTime []int
+	// why no target could be guessed (the monitor is commented out)
+	Unguessable string
+}
+
+// Discovery is a configuration generated from the components and metrics of a Cachet instance
+type Discovery struct {
+	URL      string
+	Token    string
+	Monitors []DiscoveredMonitor
+	// metrics no component name matched
+	Metrics []Metric
+}
+
+// list fetches every page of a list into v, a pointer to a slice
+func (api *CachetAPI) list(ctx context.Context, path string, v interface{}) error {
+	items := []json.RawMessage{}
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("per_page", strconv.Itoa(discoverPageSize))
+		query.Set("page", strconv.Itoa(page))
+
+		_, body, err := api.NewRequest(ctx, "GET", path+"?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+
+		pageItems := []json.RawMessage{}
+		if err := json.Unmarshal(body.Data, &pageItems); err != nil {
+			return fmt.Errorf("Cannot parse %s: %v", path, err)
+		}
+		items = append(items, pageItems...)
+
+		if len(pageItems) == 0 || page >= body.Meta.Pagination.TotalPages {
+			break
+		}
+	}
+
+	data, _ := json.Marshal(items)
+
+	return json.Unmarshal(data, v)
+}
+
+// GetComponents returns all the components
+func (api *CachetAPI) GetComponents(ctx context.Context) ([]Component, error) {
+	components := []Component{}
+	err := api.list(ctx, "/components", &components)
+
+	return components, err
+}
+
+// GetComponentGroups returns all the component groups
+func (api *CachetAPI) GetComponentGroups(ctx context.Context) ([]ComponentGroup, error) {
+	groups := []ComponentGroup{}
+	err := api.list(ctx, "/components/groups", &groups)
+
+	return groups, err
+}
+
+// GetMetrics returns all the metrics
+func (api *CachetAPI) GetMetrics(ctx context.Context) ([]Metric, error) {
+	metrics := []Metric{}
+	err := api.list(ctx, "/metrics", &metrics)
+
+	return metrics, err
+}
+
+// guessTarget returns the monitor type and target matching a component link,
+// or why none could be guessed
+func guessTarget(link string) (string, string, string) {
+	link = strings.TrimSpace(link)
+	if len(link) == 0 {
+		return "", "", "no link"
+	}
+
+	if strings.Contains(link, "://") {
+		u, err := url.Parse(link)
+		switch {
+		case err != nil || len(u.Host) == 0:
+			return "", "", "invalid link " + link
+		case u.Scheme == "http" || u.Scheme == "https":
+			return "http", link, ""
+		case len(u.Port()) > 0:
+			return "tcp", u.Host, ""
+		}
+		return "", "", "no port in link " + link
+	}
+
+	if host, port, err := net.SplitHostPort(link); err == nil && len(host) > 0 && len(port) > 0 {
+		return "tcp", link, ""
+	}
+	if net.ParseIP(link) != nil || hostnameRegexp.MatchString(link) {
+		return "icmp", link, ""
+	}
+
+	return "", "", "cannot guess a target from link " + link
+}
+
+// Discover generates a monitor for each component of the Cachet instance, matching metrics by name
+func Discover(ctx context.Context, api *CachetAPI) (*Discovery, error) {
+	components, err := api.GetComponents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := api.GetComponentGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	metrics, err := api.GetMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groupNames := map[int]string{}
+	for _, group := range groups {
+		groupNames[group.ID] = group.Name
+	}
+	names := map[string]int{}
+	for _, component := range components {
+		names[component.Name]++
+	}
+
+	discovery := &Discovery{URL: api.URL, Token: api.Token}
+	for _, component := range components {
+		monitor := DiscoveredMonitor{
+			Name:        component.Name,
+			Group:       groupNames[component.GroupID],
+			ComponentID: component.ID,
+		}
+		if names[component.Name] > 1 {
+			monitor.Name += " #" + strconv.Itoa(component.ID)
+		}
+		monitor.Type, monitor.Target, monitor.Unguessable = guessTarget(component.Link)
+		if len(monitor.Type) == 0 {
+			monitor.Type = "http"
+		}
+		discovery.Monitors = append(discovery.Monitors, monitor)
+	}
+
+	// a latency metric belongs to the component with the longest name it contains
+	for _, metric := range metrics {
+		match := -1
+		if !isLatencyMetric(metric) {
+			discovery.Metrics = append(discovery.Metrics, metric)
+			continue
+		}
+		for i, component := range components {
+			if len(component.Name) == 0 || !strings.Contains(strings.ToLower(metric.Name), strings.ToLower(component.Name)) {
+				continue
+			}
+			if match < 0 || len(component.Name) > len(components[match].Name) {
+				match = i
+			}
+		}
+
+		if match < 0 {
+			discovery.Metrics = append(discovery.Metrics, metric)
+			continue
+		}
+		discovery.Monitors[match].ResponseTime = append(discovery.Monitors[match].ResponseTime, metric.ID)
+	}
+
+	return discovery, nil
+}
+
+// isLatencyMetric tells whether the name or suffix of a metric looks like a response time
+// ("Website latency", "API response time", "Ping (ms)"), other metrics are never guessed
+func isLatencyMetric(metric Metric) bool {
+	words := strings.FieldsFunc(strings.ToLower(metric.Name+" "+metric.Suffix), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		if latencyMetricWords[word] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// yamlString quotes a string, JSON strings being valid YAML
+func yamlString(s string) string {
+	data, _ := json.Marshal(s)
+
+	return string(data)
+}
+
+func joinInts(values []int) string {
+	s := []string{}
+	for _, value := range values {
+		s = append(s, strconv.Itoa(value))
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// WriteYAML outputs the configuration in the shape of example.config.yml,
+// monitors without a guessed target are commented out
+func (discovery *Discovery) WriteYAML(w io.Writer) error {
+	lines := []string{
+		"# generated by cachet-monitor discover, review the monitors before use",
+		"api:",
+		"  url: " + yamlString(discovery.URL),
+		"  token: " + yamlString(discovery.Token),
+		"  insecure: false",
+		"monitors:",
+	}
+
+	for _, monitor := range discovery.Monitors {
+		prefix := "  "
+		if len(monitor.Group) > 0 {
+			lines = append(lines, "  # group: "+monitor.Group)
+		}
+		if len(monitor.Unguessable) > 0 {
+			lines = append(lines, "  # "+monitor.Unguessable+": set a target")
+			prefix = "  # "
+		}
+
+		lines = append(lines, prefix+"- name: "+yamlString(monitor.Name))
+		lines = append(lines, prefix+"  type: "+monitor.Type)
+		lines = append(lines, prefix+"  target: "+yamlString(monitor.Target))
+		if monitor.Type == "http" {
+			lines = append(lines, prefix+"  expected_status_code: [ 200 ]")
+		}
+		lines = append(lines, prefix+"  component_id: "+strconv.Itoa(monitor.ComponentID))
+		if len(monitor.ResponseTime) > 0 {
+			lines = append(lines, prefix+"  metrics:")
+			lines = append(lines, prefix+"    response_time: [ "+joinInts(monitor.ResponseTime)+" ]")
+		}
+		lines = append(lines, prefix+"  interval: "+strconv.Itoa(discoverInterval))
+		lines = append(lines, prefix+"  timeout: "+strconv.Itoa(discoverTimeout))
+	}
+
+	if len(discovery.Metrics) > 0 {
+		lines = append(lines, "# metrics not matched to a component:")
+		for _, metric := range discovery.Metrics {
+			lines = append(lines, "#   "+strconv.Itoa(metric.ID)+": "+metric.Name)
+		}
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+
+	return err
+}
+
+// WriteJSON outputs the configuration as JSON, monitors without a guessed target
+// are listed under disabled_monitors (ignored when loading the configuration)
+func (discovery *Discovery) WriteJSON(w io.Writer) error {
+	type metricsConfig struct {
+		ResponseTime []int `json:"response_time"`
+	}
+	type monitorConfig struct {
+		Name               string         `json:"name"`
+		Type               string         `json:"type"`
+		Target             string         `json:"target"`
+		ExpectedStatusCode []int          `json:"expected_status_code,omitempty"`
+		ComponentID        int            `json:"component_id"`
+		Metrics            *metricsConfig `json:"metrics,omitempty"`
+		Interval           int            `json:"interval"`
+		Timeout            int            `json:"timeout"`
+		Reason             string         `json:"reason,omitempty"`
+	}
+
+	config := struct {
+		API struct {
+			URL      string `json:"url"`
+			Token    string `json:"token"`
+			Insecure bool   `json:"insecure"`
+		} `json:"api"`
+		Monitors          []monitorConfig `json:"monitors"`
+		DisabledMonitors  []monitorConfig `json:"disabled_monitors,omitempty"`
+		UnassignedMetrics []Metric        `json:"unassigned_metrics,omitempty"`
+	}{Monitors: []monitorConfig{}, UnassignedMetrics: discovery.Metrics}
+	config.API.URL = discovery.URL
+	config.API.Token = discovery.Token
+
+	for _, monitor := range discovery.Monitors {
+		mon := monitorConfig{
+			Name:        monitor.Name,
+			Type:        monitor.Type,
+			Target:      monitor.Target,
+			ComponentID: monitor.ComponentID,
+			Interval:    discoverInterval,
+			Timeout:     discoverTimeout,
+			Reason:      monitor.Unguessable,
+		}
+		if monitor.Type == "http" {
+			mon.ExpectedStatusCode = []int{200}
+		}
+		if len(monitor.ResponseTime) > 0 {
+			mon.Metrics = &metricsConfig{ResponseTime: monitor.ResponseTime}
+		}
+
+		if len(monitor.Unguessable) > 0 {
+			config.DisabledMonitors = append(config.DisabledMonitors, mon)
+		} else {
+			config.Monitors = append(config.Monitors, mon)
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(config)
+}
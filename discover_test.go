@@ -0,0 +1,112 @@
+package cachet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"cachet/cachettest"
+)
+
+func TestGuessTarget(t *testing.T) {
+	tests := map[string]string{
+		"https://example.com/health": "http https://example.com/health",
+		"smtp://mail.example.com:25": "tcp mail.example.com:25",
+		"db.example.com:5432":        "tcp db.example.com:5432",
+		"gateway.example.com":        "icmp gateway.example.com",
+		"10.0.0.1":                   "icmp 10.0.0.1",
+	}
+	for link, expected := range tests {
+		monType, target, reason := guessTarget(link)
+		if monType+" "+target != expected || len(reason) > 0 {
+			t.Errorf("%s: expected %s, got %s %s (%s)", link, expected, monType, target, reason)
+		}
+	}
+
+	for _, link := range []string{"", "see the wiki", "ftp://files.example.com"} {
+		if monType, _, reason := guessTarget(link); len(monType) > 0 || len(reason) == 0 {
+			t.Errorf("%q: expected no target, got %s", link, monType)
+		}
+	}
+}
+
+// newDiscoveryServer serves the lists one item per page
+func newDiscoveryServer() *cachettest.Server {
+	fake := cachettest.NewServer()
+	fake.PerPage = 1
+
+	fake.AddComponent(cachettest.Component{Name: "Website", Link: "https://example.com", GroupID: 1})
+	fake.AddComponent(cachettest.Component{Name: "Database", GroupID: 2})
+	fake.AddComponent(cachettest.Component{Name: "Website Admin", Link: "https://admin.example.com", GroupID: 1})
+	fake.AddGroup(cachettest.ComponentGroup{Name: "Web"})
+	fake.AddGroup(cachettest.ComponentGroup{Name: "Backend"})
+	for _, name := range []string{"Website Admin latency", "Website latency", "Signups", "Website availability", "Admin response time"} {
+		fake.AddMetric(cachettest.Metric{Name: name})
+	}
+
+	return fake
+}
+
+func TestDiscover(t *testing.T) {
+	fake := newDiscoveryServer()
+	defer fake.Close()
+
+	discovery, err := Discover(context.Background(), &CachetAPI{URL: fake.URL, Token: fake.Token, retryWait: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(discovery.Monitors) != 3 {
+		t.Fatalf("expected a monitor per component across pages, got %d", len(discovery.Monitors))
+	}
+	website, database, admin := discovery.Monitors[0], discovery.Monitors[1], discovery.Monitors[2]
+	if website.Group != "Web" || website.Type != "http" || len(website.ResponseTime) != 1 || website.ResponseTime[0] != 2 {
+		t.Errorf("unexpected website monitor: %+v", website)
+	}
+	if len(admin.ResponseTime) != 1 || admin.ResponseTime[0] != 1 {
+		t.Errorf("expected the longest component name to match the metric, got %+v", admin)
+	}
+	if len(database.Unguessable) == 0 {
+		t.Errorf("expected no target for the database, got %+v", database)
+	}
+	// "Admin response time" matches no component name
+	if len(discovery.Metrics) != 3 || discovery.Metrics[0].Name != "Signups" || discovery.Metrics[1].Name != "Website availability" {
+		t.Errorf("expected Signups, the availability and the admin response time to be unmatched, got %v", discovery.Metrics)
+	}
+
+	var out bytes.Buffer
+	if err := discovery.WriteYAML(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `  # - name: "Database"`) {
+		t.Errorf("expected the database monitor to be commented out:\n%s", out.String())
+	}
+
+	var config struct {
+		API      map[string]interface{}   `yaml:"api"`
+		Monitors []map[string]interface{} `yaml:"monitors"`
+	}
+	if err := yaml.Unmarshal(out.Bytes(), &config); err != nil {
+		t.Fatalf("expected valid YAML, got %v:\n%s", err, out.String())
+	}
+	if len(config.Monitors) != 2 || config.Monitors[0]["component_id"] != 1 || config.API["token"] != cachettest.DefaultToken {
+		t.Errorf("unexpected configuration: %+v", config)
+	}
+
+	out.Reset()
+	if err := discovery.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var jsonConfig struct {
+		Monitors         []map[string]interface{} `json:"monitors"`
+		DisabledMonitors []map[string]interface{} `json:"disabled_monitors"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &jsonConfig); err != nil || len(jsonConfig.Monitors) != 2 || len(jsonConfig.DisabledMonitors) != 1 {
+		t.Errorf("unexpected JSON configuration (%v):\n%s", err, out.String())
+	}
+}
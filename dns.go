@@ -69,11 +69,20 @@ func (monitor *DNSMonitor) test(l *logrus.Entry) bool {
 	c := new(dns.Client)
 	r, _, err := c.Exchange(m, monitor.DNS)
 	if err != nil {
+		monitor.lastFailReason = "DNS error: " + err.Error()
 		logrus.Warnf("DNS error: %v", err)
 		return false
 	}
 
+	answers := []string{}
+	for _, answer := range r.Answer {
+		answers = append(answers, answer.String())
+	}
+	monitor.setDetail("rcode", dns.RcodeToString[r.Rcode])
+	monitor.setDetail("answers", answers)
+
 	if r.Rcode != dns.RcodeSuccess {
+		monitor.lastFailReason = "DNS response code: " + dns.RcodeToString[r.Rcode]
 		return false
 	}
 
@@ -87,6 +96,7 @@ func (monitor *DNSMonitor) test(l *logrus.Entry) bool {
 		}
 
 		if !found {
+			monitor.lastFailReason = "Expected answer not found: " + check.Exact + check.Regex
 			logrus.Warnf("DNS check failed: %v. Not found in any of %v", check, r.Answer)
 			return false
 		}
@@ -0,0 +1,196 @@
+package cachet
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// IDs given to the resources created during a dry run, far from the real ones
+const dryRunFirstID = 1000000000
+
+var (
+	dryRunComponentPath = regexp.MustCompile(`^/components/(\d+)$`)
+	dryRunIncidentPath  = regexp.MustCompile(`^/incidents(/(\d+))?$`)
+)
+
+// DryRunWrite is a request to the Cachet API recorded instead of being sent
+type DryRunWrite struct {
+	Time    time.Time       `json:"time"`
+	Method  string          `json:"method"`
+	URL     string          `json:"url"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// DryRunRecorder records the writes to the Cachet API, reads are still sent
+type DryRunRecorder struct {
+	mu     sync.Mutex
+	writes []DryRunWrite
+	nextID int
+	// component statuses set during the dry run, by component ID
+	statuses map[int]int
+	// components created during the dry run, which Cachet does not know about
+	components map[int]map[string]interface{}
+}
+
+// record logs and records a write, and returns the response Cachet would have sent:
+// the payload with an ID
+func (recorder *DryRunRecorder) record(method, url string, payload []byte) CachetResponse {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	logrus.Infof("Dry run: %s %s %s", method, url, string(payload))
+
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	recorder.writes = append(recorder.writes, DryRunWrite{
+		Time:    time.Now(),
+		Method:  method,
+		URL:     url,
+		Payload: json.RawMessage(payload),
+	})
+
+	data := map[string]interface{}{}
+	json.Unmarshal(payload, &data)
+
+	if match := dryRunComponentPath.FindStringSubmatch(url); match != nil && method == "PUT" {
+		id, _ := strconv.Atoi(match[1])
+		if status, ok := data["status"].(float64); ok {
+			if recorder.statuses == nil {
+				recorder.statuses = map[int]int{}
+			}
+			recorder.statuses[id] = int(status)
+		}
+		data["id"] = id
+	} else if match := dryRunIncidentPath.FindStringSubmatch(url); match != nil && len(match[2]) > 0 {
+		data["id"], _ = strconv.Atoi(match[2])
+	} else if method == "POST" {
+		if recorder.nextID == 0 {
+			recorder.nextID = dryRunFirstID
+		}
+		data["id"] = recorder.nextID
+		if url == "/components" {
+			if recorder.components == nil {
+				recorder.components = map[int]map[string]interface{}{}
+			}
+			recorder.components[recorder.nextID] = data
+		}
+		recorder.nextID++
+	}
+
+	var body CachetResponse
+	body.Data, _ = json.Marshal(data)
+
+	return body
+}
+
+// read answers the reads about the components created during the dry run, which would fail
+// or find nothing in Cachet: the component itself and its (empty) list of incidents
+func (recorder *DryRunRecorder) read(requestURL string) (CachetResponse, bool) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	var body CachetResponse
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return body, false
+	}
+
+	if match := dryRunComponentPath.FindStringSubmatch(u.Path); match != nil {
+		id, _ := strconv.Atoi(match[1])
+		if component, ok := recorder.components[id]; ok {
+			body.Data, _ = json.Marshal(component)
+			return body, true
+		}
+	} else if u.Path == "/incidents" {
+		id, _ := strconv.Atoi(u.Query().Get("component_id"))
+		if _, ok := recorder.components[id]; ok {
+			body.Data = json.RawMessage("[]")
+			return body, true
+		}
+	}
+
+	return body, false
+}
+
+// componentStatus returns the status set on a component during the dry run
+func (recorder *DryRunRecorder) componentStatus(id int) (int, bool) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	status, ok := recorder.statuses[id]
+
+	return status, ok
+}
+
+// Writes returns the recorded writes, oldest first
+func (recorder *DryRunRecorder) Writes() []DryRunWrite {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	return append([]DryRunWrite{}, recorder.writes...)
+}
+
+// Summary lists the component status changes and incidents the recorded writes would have made,
+// followed by the number of metric points and other writes
+func (recorder *DryRunRecorder) Summary() []string {
+	statusChanges := []string{}
+	incidents := []string{}
+	updates := 0
+	metricPoints := map[string]int{}
+	others := 0
+
+	for _, write := range recorder.Writes() {
+		var data struct {
+			Name            string `json:"name"`
+			Status          int    `json:"status"`
+			ComponentID     int    `json:"component_id"`
+			ComponentStatus int    `json:"component_status"`
+		}
+		json.Unmarshal(write.Payload, &data)
+		at := write.Time.Format("15:04:05")
+
+		switch {
+		case write.Method == "PUT" && dryRunComponentPath.MatchString(write.URL):
+			statusChanges = append(statusChanges, fmt.Sprintf("  %s component %s => status %d", at, strings.TrimPrefix(write.URL, "/components/"), data.Status))
+		case dryRunIncidentPath.MatchString(write.URL):
+			action := "create"
+			if write.Method == "PUT" {
+				action = "update " + strings.TrimPrefix(write.URL, "/incidents/")
+			}
+			incidents = append(incidents, fmt.Sprintf("  %s %s incident '%s' (status %d, component %d => status %d)", at, action, data.Name, data.Status, data.ComponentID, data.ComponentStatus))
+		case strings.HasSuffix(write.URL, "/updates"):
+			updates++
+		case strings.HasSuffix(write.URL, "/points"):
+			metricPoints[strings.TrimSuffix(strings.TrimPrefix(write.URL, "/metrics/"), "/points")]++
+		default:
+			others++
+		}
+	}
+
+	lines := []string{strconv.Itoa(len(statusChanges)) + " component status change(s)"}
+	lines = append(lines, statusChanges...)
+	lines = append(lines, strconv.Itoa(len(incidents))+" incident(s) created or updated")
+	lines = append(lines, incidents...)
+	lines = append(lines, strconv.Itoa(updates)+" incident update(s)")
+
+	metrics := []string{}
+	for id, count := range metricPoints {
+		metrics = append(metrics, "  metric "+id+": "+strconv.Itoa(count))
+	}
+	sort.Strings(metrics)
+	lines = append(lines, strconv.Itoa(len(metrics))+" metric(s) with points")
+	lines = append(lines, metrics...)
+	lines = append(lines, strconv.Itoa(others)+" other write(s)")
+
+	return lines
+}
@@ -0,0 +1,143 @@
+package cachet
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"cachet/cachettest"
+)
+
+func TestDryRun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("expected only reads to be sent, got %s %s", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"data":{"id":7,"name":"Website","status":1}}`))
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	l := logrus.WithFields(logrus.Fields{})
+	cfg := &CachetMonitor{API: CachetAPI{URL: srv.URL, retryWait: time.Millisecond}, DryRun: true}
+	cfg.Validate()
+	if cfg.API.DryRun == nil {
+		t.Fatal("expected the dry run to record the writes")
+	}
+
+	mon := &AbstractMonitor{Name: "web", ComponentID: 7, currentStatus: 1}
+	if _, err := cfg.API.SetComponentStatus(ctx, mon, 3); err != nil || mon.currentStatus != 3 {
+		t.Errorf("expected the status change to succeed, got %d (%v)", mon.currentStatus, err)
+	}
+	if component, err := cfg.API.GetComponentData(ctx, 7); err != nil || component.Name != "Website" || component.Status != 3 {
+		t.Errorf("expected the component to be read with its dry run status, got %+v (%v)", component, err)
+	}
+
+	if err := cfg.API.SendMetrics(ctx, l, "response time", []int{4, 5}, 120); err != nil {
+		t.Error(err)
+	}
+
+	incident := &Incident{Name: "Website down", ComponentID: 7}
+	incident.SetInvestigating()
+	if err := incident.Send(ctx, cfg); err != nil || incident.ID < dryRunFirstID {
+		t.Errorf("expected a dry run incident ID, got %d (%v)", incident.ID, err)
+	}
+	incident.SetFixed()
+	id := incident.ID
+	if err := incident.Send(ctx, cfg); err != nil || incident.ID != id {
+		t.Errorf("expected the incident ID to be kept on update, got %d (%v)", incident.ID, err)
+	}
+
+	writes := cfg.API.DryRun.Writes()
+	if len(writes) != 5 || writes[0].Method != "PUT" || string(writes[0].Payload) != `{"status":3}` {
+		t.Fatalf("unexpected writes: %v", writes)
+	}
+
+	summary := strings.Join(cfg.API.DryRun.Summary(), "\n")
+	for _, expected := range []string{"1 component status change(s)", "component 7 => status 3", "2 incident(s)", "create incident 'Website down' (status 1, component 7 => status 4)", "2 metric(s) with points"} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("expected %q in the summary:\n%s", expected, summary)
+		}
+	}
+}
+
+func TestDryRunKeepsQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachet-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pending := `{"type":"component_status","id":1,"status":4,"timestamp":1}` + "\n"
+	path := filepath.Join(dir, queueFileName)
+	if err := ioutil.WriteFile(path, []byte(pending), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":"Pong!"}`))
+	}))
+	defer srv.Close()
+
+	cfg := &CachetMonitor{API: CachetAPI{URL: srv.URL}, Queue: OfflineQueue{Directory: dir}, DryRun: true}
+	cfg.Validate()
+	if err := cfg.Queue.Open(&cfg.API); err != nil {
+		t.Fatal(err)
+	}
+	defer cfg.Queue.Close()
+
+	if cfg.Queue.Push(QueueEntry{Type: QueueEntryMetric, ID: 2, Value: 1, Timestamp: 2}) {
+		t.Error("expected the queue to be disabled during a dry run")
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != pending {
+		t.Errorf("expected the pending writes to be kept, got %q (%v)", data, err)
+	}
+	if writes := cfg.API.DryRun.Writes(); len(writes) > 0 {
+		t.Errorf("expected no queued write to be replayed, got %v", writes)
+	}
+}
+
+func TestDryRunProvision(t *testing.T) {
+	fake := cachettest.NewServer()
+	defer fake.Close()
+
+	cfg := &CachetMonitor{API: CachetAPI{URL: fake.URL, Token: fake.Token, retryWait: time.Millisecond}, DryRun: true}
+	cfg.Validate()
+
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", Component: ComponentRef{Name: "Website"}}}
+	if errs := mon.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if err := cfg.Provision(context.Background(), []MonitorInterface{mon}); err != nil {
+		t.Fatal(err)
+	}
+	if mon.ComponentID < dryRunFirstID {
+		t.Fatalf("expected a dry run component ID, got %d", mon.ComponentID)
+	}
+
+	// the created component is only known to the recorder
+	if !mon.Init(cfg) || !mon.Enabled || mon.currentStatus != 1 || mon.incident != nil {
+		t.Fatalf("expected the monitor to start on the created component, got enabled=%t status=%d", mon.Enabled, mon.currentStatus)
+	}
+
+	incident := &Incident{Name: "Website down", ComponentID: mon.ComponentID}
+	incident.SetInvestigating()
+	if err := incident.Send(context.Background(), cfg); err != nil {
+		t.Errorf("expected the incident to be recorded, got %v", err)
+	}
+
+	for _, request := range fake.Requests() {
+		if request.Method != "GET" || strings.Contains(request.URL, strconv.Itoa(mon.ComponentID)) {
+			t.Errorf("unexpected request to Cachet: %s %s", request.Method, request.URL)
+		}
+	}
+}
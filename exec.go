@@ -0,0 +1,296 @@
+package cachet
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Nagios plugin exit codes
+const (
+	ExecStatusOK       = 0
+	ExecStatusWarning  = 1
+	ExecStatusCritical = 2
+	ExecStatusUnknown  = 3
+)
+
+// time given to the command's children to release its output once it exited or was killed
+const execOutputDelay = time.Second
+
+var execStatusNames = map[int]string{
+	ExecStatusOK:       "OK",
+	ExecStatusWarning:  "WARNING",
+	ExecStatusCritical: "CRITICAL",
+	ExecStatusUnknown:  "UNKNOWN",
+}
+
+type ExecMonitor struct {
+	AbstractMonitor `mapstructure:",squash"`
+
+	Command string
+	Args    []string
+
+	// perfdata label => metric IDs
+	PerfData map[string][]int `mapstructure:"perfdata"`
+}
+
+// PerfDatum is a single Nagios performance data value ('label'=value[UOM];[warn];[crit];[min];[max])
+type PerfDatum struct {
+	Label string
+	Value float64
+	UOM   string
+}
+
+func (monitor *ExecMonitor) test(l *logrus.Entry) bool {
+	stdout, stderr, timedOut, err := monitor.run()
+
+	status := ExecStatusOK
+	if timedOut {
+		monitor.lastFailReason = "Command timed out after " + (monitor.Timeout * time.Second).String()
+		l.Infof("Exec failure: %s", monitor.lastFailReason)
+		return false
+	} else if exitErr, ok := err.(*exec.ExitError); ok {
+		status = ExecStatusUnknown
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			status = ws.ExitStatus()
+		}
+	} else if err != nil {
+		monitor.lastFailReason = "Unable to run command: " + err.Error()
+		l.Infof("Exec failure: %s", monitor.lastFailReason)
+		return false
+	}
+
+	output, perfData := parsePluginOutput(stdout)
+	if len(output) == 0 {
+		output = strings.TrimSpace(stderr)
+	}
+
+	for _, datum := range perfData {
+		if ids, ok := monitor.PerfData[datum.Label]; ok {
+			monitor.addMetricPoint(datum.Label, ids, datum.Value)
+		}
+	}
+
+	statusName, ok := execStatusNames[status]
+	if !ok {
+		statusName = "exit code " + strconv.Itoa(status)
+	}
+	l.Debugf("Command returned %s: %s", statusName, output)
+	monitor.setDetail("status", statusName)
+	monitor.setDetail("output", output)
+
+	if status != ExecStatusOK {
+		monitor.warning = (status == ExecStatusWarning)
+		monitor.lastFailReason = statusName + ": " + output
+		l.Infof("Exec failure: %s", monitor.lastFailReason)
+		return false
+	}
+
+	monitor.triggerShellHook(l, "on_success", monitor.ShellHookOnSuccess, output)
+
+	return true
+}
+
+// run executes the command in its own process group, killed with its children on timeout.
+// The output is read from pipes closed by run itself, so that a background child keeping
+// them open cannot block the check.
+func (monitor *ExecMonitor) run() (string, string, bool, error) {
+	stdout := new(execOutput)
+	stderr := new(execOutput)
+
+	cmd := exec.Command(monitor.Command, monitor.Args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		return "", "", false, err
+	}
+	defer outR.Close()
+	errR, errW, err := os.Pipe()
+	if err != nil {
+		outW.Close()
+		return "", "", false, err
+	}
+	defer errR.Close()
+
+	cmd.Stdout = outW
+	cmd.Stderr = errW
+	err = cmd.Start()
+	// only the command (and its children) hold the write ends now
+	outW.Close()
+	errW.Close()
+	if err != nil {
+		return "", "", false, err
+	}
+
+	copied := make(chan bool, 2)
+	go func() { io.Copy(stdout, outR); copied <- true }()
+	go func() { io.Copy(stderr, errR); copied <- true }()
+
+	waitC := make(chan error, 1)
+	go func() { waitC <- cmd.Wait() }()
+
+	timer := time.NewTimer(monitor.Timeout * time.Second)
+	defer timer.Stop()
+
+	timedOut := false
+	select {
+	case err = <-waitC:
+	case <-timer.C:
+		timedOut = true
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		err = <-waitC
+	}
+
+	delay := time.NewTimer(execOutputDelay)
+	defer delay.Stop()
+	for pending := 2; pending > 0; pending-- {
+		select {
+		case <-copied:
+		case <-delay.C:
+			// children left in the background still hold the output: keep what has been read
+			// (the deferred Close ends the copy)
+			return stdout.String(), stderr.String(), timedOut, err
+		}
+	}
+
+	return stdout.String(), stderr.String(), timedOut, err
+}
+
+// execOutput collects the output of a command, read while a copy may still be writing to it
+type execOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (out *execOutput) Write(p []byte) (int, error) {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	return out.buf.Write(p)
+}
+
+func (out *execOutput) String() string {
+	out.mu.Lock()
+	defer out.mu.Unlock()
+
+	return out.buf.String()
+}
+
+// parsePluginOutput returns the first line of the plugin text output and all its performance data
+func parsePluginOutput(out string) (string, []PerfDatum) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+
+	output := lines[0]
+	perfData := []PerfDatum{}
+	if i := strings.Index(output, "|"); i >= 0 {
+		perfData = append(perfData, parsePerfData(output[i+1:])...)
+		output = output[:i]
+	}
+
+	// long output: perfdata may follow a '|' on any of the next lines
+	for _, line := range lines[1:] {
+		if i := strings.Index(line, "|"); i >= 0 {
+			perfData = append(perfData, parsePerfData(line[i+1:])...)
+		}
+	}
+
+	return strings.TrimSpace(output), perfData
+}
+
+// parsePerfData parses a space separated list of 'label'=value[UOM];[warn];[crit];[min];[max]
+func parsePerfData(s string) []PerfDatum {
+	data := []PerfDatum{}
+
+	for s = strings.TrimSpace(s); len(s) > 0; s = strings.TrimSpace(s) {
+		var label string
+		if s[0] == '\'' {
+			// quoted labels may contain spaces, '' is an escaped quote
+			end := 1
+			for ; end < len(s); end++ {
+				if s[end] == '\'' {
+					if end+1 < len(s) && s[end+1] == '\'' {
+						end++
+						continue
+					}
+					break
+				}
+			}
+			if end >= len(s) {
+				// unterminated label
+				break
+			}
+			label = strings.Replace(s[1:end], "''", "'", -1)
+			s = s[end+1:]
+		} else {
+			end := strings.IndexAny(s, "= ")
+			if end < 0 {
+				break
+			}
+			label = s[:end]
+			s = s[end:]
+		}
+
+		if len(s) == 0 || s[0] != '=' {
+			// malformed entry: skip to the next one
+			if i := strings.Index(s, " "); i >= 0 {
+				s = s[i:]
+				continue
+			}
+			break
+		}
+
+		value := s[1:]
+		if i := strings.Index(value, " "); i >= 0 {
+			s = value[i:]
+			value = value[:i]
+		} else {
+			s = ""
+		}
+		value = strings.Split(value, ";")[0]
+
+		num := strings.TrimRightFunc(value, func(r rune) bool {
+			return !(r >= '0' && r <= '9') && r != '.'
+		})
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil {
+			continue
+		}
+
+		data = append(data, PerfDatum{Label: label, Value: f, UOM: value[len(num):]})
+	}
+
+	return data
+}
+
+// TODO: test
+func (mon *ExecMonitor) Validate() []string {
+	mon.Template.Investigating.SetDefault(defaultHTTPInvestigatingTpl)
+	mon.Template.Fixed.SetDefault(defaultHTTPFixedTpl)
+
+	errs := mon.AbstractMonitor.Validate()
+
+	if len(mon.Command) == 0 {
+		errs = append(errs, "'Command' has not been set")
+	} else if len(mon.Target) == 0 {
+		mon.Target = mon.Command
+	}
+
+	return errs
+}
+
+func (mon *ExecMonitor) Describe() []string {
+	features := mon.AbstractMonitor.Describe()
+	features = append(features, "Command: "+strings.Join(append([]string{mon.Command}, mon.Args...), " "))
+	features = append(features, "Perfdata metrics: "+strconv.Itoa(len(mon.PerfData)))
+
+	return features
+}
@@ -0,0 +1,78 @@
+package cachet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestParsePluginOutput(t *testing.T) {
+	output, perfData := parsePluginOutput("DISK WARNING - free space: / 3326 MB (56%); | /=2643MB;5948;5958;0;5968 'data volume'=12.5%;80;90\nlong output | inodes=7;;\n")
+
+	if output != "DISK WARNING - free space: / 3326 MB (56%);" {
+		t.Errorf("unexpected output: %q", output)
+	}
+
+	expected := []PerfDatum{
+		{Label: "/", Value: 2643, UOM: "MB"},
+		{Label: "data volume", Value: 12.5, UOM: "%"},
+		{Label: "inodes", Value: 7, UOM: ""},
+	}
+	if len(perfData) != len(expected) {
+		t.Fatalf("expected %d perfdata values, got %v", len(expected), perfData)
+	}
+	for i, datum := range expected {
+		if perfData[i] != datum {
+			t.Errorf("perfdata #%d: expected %v, got %v", i, datum, perfData[i])
+		}
+	}
+}
+
+func TestParsePerfDataMalformed(t *testing.T) {
+	perfData := parsePerfData("novalue 'it''s'=1s load=U time=0.5")
+
+	if len(perfData) != 2 {
+		t.Fatalf("expected 2 perfdata values, got %v", perfData)
+	}
+	if perfData[0].Label != "it's" || perfData[0].Value != 1 {
+		t.Errorf("unexpected escaped label parsing: %v", perfData[0])
+	}
+	if perfData[1].Label != "time" || perfData[1].Value != 0.5 {
+		t.Errorf("unexpected value parsing: %v", perfData[1])
+	}
+}
+
+func TestExecMonitorStatus(t *testing.T) {
+	l := logrus.WithFields(logrus.Fields{"monitor": "exec"})
+
+	tests := []struct {
+		script  string
+		timeout time.Duration
+		up      bool
+		warning bool
+		reason  string
+	}{
+		{"echo 'DISK OK | free=80%'", 5, true, false, ""},
+		{"echo 'DISK WARNING - 15% free'; exit 1", 5, false, true, "WARNING: DISK WARNING - 15% free"},
+		{"echo 'DISK CRITICAL - 2% free'; exit 2", 5, false, false, "CRITICAL: DISK CRITICAL - 2% free"},
+		{"echo 'no such disk' >&2; exit 3", 5, false, false, "UNKNOWN: no such disk"},
+		{"exit 42", 5, false, false, "exit code 42: "},
+		// the backgrounded sleep keeps stdout open after the script exited
+		{"sleep 30 & echo 'started'", 5, true, false, ""},
+		{"sleep 30", 1, false, false, "Command timed out after 1s"},
+	}
+
+	for _, test := range tests {
+		mon := &ExecMonitor{AbstractMonitor: AbstractMonitor{Name: "exec", Timeout: test.timeout}, Command: "sh", Args: []string{"-c", test.script}}
+
+		start := time.Now()
+		up := mon.test(l)
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("%q: expected the check to end after the timeout, took %v", test.script, elapsed)
+		}
+		if up != test.up || mon.warning != test.warning || mon.lastFailReason != test.reason {
+			t.Errorf("%q: expected up=%t warning=%t reason=%q, got up=%t warning=%t reason=%q", test.script, test.up, test.warning, test.reason, up, mon.warning, mon.lastFailReason)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package cachet
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const DefaultHeartbeatListen = ":9875"
+
+// maximum size of the failure message accepted on /fail
+const heartbeatMaxBody = 1024
+
+// the deadline is checked at least every minute, whatever the interval of the job
+const heartbeatCheckInterval = time.Minute
+
+// Investigating template
+var defaultHeartbeatInvestigatingTpl = MessageTemplate{
+	Subject: `{{ .Monitor.Name }} - {{ .SystemName }}`,
+	Message: `{{ .Monitor.Name }} heartbeat **missed** (server time: {{ .now }})
+
+{{ .FailReason }}`,
+}
+
+// HeartbeatServer receives pings for heartbeat monitors
+type HeartbeatServer struct {
+	Listen string `json:"listen" yaml:"listen"`
+
+	mu       sync.Mutex
+	monitors map[string]*HeartbeatMonitor
+	server   *http.Server
+}
+
+type HeartbeatMonitor struct {
+	AbstractMonitor `mapstructure:",squash"`
+
+	// unique secret used in the ping URL (/ping/<token>)
+	Token string
+	// seconds allowed on top of the interval before the heartbeat is considered missed
+	Grace time.Duration
+
+	mu       sync.Mutex
+	lastSeen time.Time
+	started  time.Time
+	failed   bool
+	failMsg  string
+	runtime  int64
+}
+
+func (srv *HeartbeatServer) register(mon *HeartbeatMonitor) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.monitors == nil {
+		srv.monitors = map[string]*HeartbeatMonitor{}
+	}
+	if other, ok := srv.monitors[mon.Token]; ok && other != mon {
+		return errors.New("heartbeat token already used by monitor " + other.Name)
+	}
+	srv.monitors[mon.Token] = mon
+
+	return nil
+}
+
+func (srv *HeartbeatServer) unregister(mon *HeartbeatMonitor) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.monitors[mon.Token] == mon {
+		delete(srv.monitors, mon.Token)
+	}
+}
+
+// Start listens for pings if at least one heartbeat monitor has been registered
+func (srv *HeartbeatServer) Start() error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if len(srv.monitors) == 0 || srv.server != nil {
+		return nil
+	}
+
+	if len(srv.Listen) == 0 {
+		srv.Listen = DefaultHeartbeatListen
+	}
+
+	ln, err := net.Listen("tcp", srv.Listen)
+	if err != nil {
+		return err
+	}
+
+	srv.server = &http.Server{Handler: srv}
+	go srv.server.Serve(ln)
+
+	logrus.Infof("Heartbeat receiver listening on %s", ln.Addr())
+
+	return nil
+}
+
+// Stop closes the listener
+func (srv *HeartbeatServer) Stop() {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if srv.server != nil {
+		srv.server.Close()
+		srv.server = nil
+	}
+}
+
+// ServeHTTP handles /ping/<token>, /ping/<token>/start and /ping/<token>/fail
+func (srv *HeartbeatServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "ping" {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case "GET", "HEAD", "POST", "PUT":
+		break
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	srv.mu.Lock()
+	mon, ok := srv.monitors[parts[1]]
+	srv.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	event := ""
+	if len(parts) == 3 {
+		event = parts[2]
+	}
+
+	switch event {
+	case "":
+		mon.ping(false, "")
+	case "start":
+		mon.start()
+	case "fail":
+		body, _ := ioutil.ReadAll(io.LimitReader(r.Body, heartbeatMaxBody))
+		mon.ping(true, strings.TrimSpace(string(body)))
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	logrus.WithFields(logrus.Fields{"monitor": mon.Name}).Debugf("Heartbeat received (event: '%s', from: %s)", event, r.RemoteAddr)
+
+	w.Write([]byte("OK\n"))
+}
+
+func (mon *HeartbeatMonitor) start() {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+
+	mon.started = time.Now()
+}
+
+func (mon *HeartbeatMonitor) ping(failed bool, msg string) {
+	mon.mu.Lock()
+	defer mon.mu.Unlock()
+
+	now := time.Now()
+	mon.lastSeen = now
+	mon.failed = failed
+	mon.failMsg = msg
+
+	if !mon.started.IsZero() {
+		mon.runtime = int64(now.Sub(mon.started) / time.Millisecond)
+		mon.started = time.Time{}
+	}
+}
+
+// TODO: test
+func (monitor *HeartbeatMonitor) test(l *logrus.Entry) bool {
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+
+	// only report a runtime once per completed run
+	if monitor.runtime >= 0 {
+		monitor.customLag = monitor.runtime
+		monitor.runtime = -1
+	} else {
+		monitor.noLag = true
+	}
+
+	if monitor.failed {
+		monitor.lastFailReason = "Job reported a failure at " + monitor.lastSeen.Format(monitor.config.DateFormat)
+		if len(monitor.failMsg) > 0 {
+			monitor.lastFailReason += ": " + monitor.failMsg
+		}
+		l.Infof("Heartbeat failure: %s", monitor.lastFailReason)
+		return false
+	}
+
+	deadline := monitor.lastSeen.Add(time.Duration(monitor.Interval*time.Second) + time.Duration(monitor.Grace*time.Second))
+	if time.Now().After(deadline) {
+		monitor.lastFailReason = "No heartbeat received since " + monitor.lastSeen.Format(monitor.config.DateFormat)
+		l.Infof("Heartbeat failure: %s", monitor.lastFailReason)
+		return false
+	}
+
+	monitor.triggerShellHook(l, "on_success", monitor.ShellHookOnSuccess, "")
+
+	return true
+}
+
+// checkInterval ticks faster than the expected pings, so a missed deadline is
+// noticed (and the history filled) within minutes rather than intervals
+func (mon *HeartbeatMonitor) checkInterval() time.Duration {
+	if interval := mon.AbstractMonitor.checkInterval(); interval < heartbeatCheckInterval {
+		return interval
+	}
+
+	return heartbeatCheckInterval
+}
+
+func (mon *HeartbeatMonitor) Init(cfg *CachetMonitor) bool {
+	mon.mu.Lock()
+	// give the job a full interval to check in after startup
+	mon.lastSeen = time.Now()
+	mon.runtime = -1
+	mon.mu.Unlock()
+
+	IsValid := mon.AbstractMonitor.Init(cfg)
+
+	if err := cfg.Heartbeat.register(mon); err != nil {
+		logrus.Errorf("Unable to register heartbeat monitor %s: %v", mon.Name, err)
+		IsValid = false
+	}
+
+	return IsValid
+}
+
+func (mon *HeartbeatMonitor) ClockStop() {
+	mon.AbstractMonitor.ClockStop()
+
+	if mon.config != nil {
+		mon.config.Heartbeat.unregister(mon)
+	}
+}
+
+// TODO: test
+func (mon *HeartbeatMonitor) Validate() []string {
+	mon.Template.Investigating.SetDefault(defaultHeartbeatInvestigatingTpl)
+	mon.Template.Fixed.SetDefault(defaultHTTPFixedTpl)
+
+	// pings are received, never sent: the timeout is unused and must not be
+	// compared against a daily interval
+	mon.Timeout = 1
+
+	errs := mon.AbstractMonitor.Validate()
+
+	if len(mon.Token) == 0 {
+		errs = append(errs, "'Token' has not been set")
+	} else if strings.Contains(mon.Token, "/") {
+		errs = append(errs, "'Token' must not contain '/'")
+	} else {
+		mon.Target = "/ping/" + mon.Token
+	}
+
+	if mon.Grace < 0 {
+		mon.Grace = 0
+	}
+
+	return errs
+}
+
+func (mon *HeartbeatMonitor) Describe() []string {
+	features := mon.AbstractMonitor.Describe()
+	features = append(features, "Grace period: "+(mon.Grace*time.Second).String())
+	features = append(features, "Deadline checked every: "+mon.checkInterval().String())
+
+	return features
+}
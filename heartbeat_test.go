@@ -0,0 +1,114 @@
+package cachet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"cachet/cachettest"
+)
+
+func TestHeartbeatServer(t *testing.T) {
+	cfg := &CachetMonitor{DateFormat: DefaultTimeFormat}
+	mon := &HeartbeatMonitor{Token: "secret"}
+	mon.Name = "job"
+	mon.ComponentID = 1
+	mon.config = cfg
+	mon.runtime = -1
+
+	// a daily job, without a timeout
+	mon.Interval = 86400
+	if errs := mon.Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected validation errors: %v", errs)
+	}
+	if err := cfg.Heartbeat.register(mon); err != nil {
+		t.Fatal(err)
+	}
+
+	l := logrus.WithFields(logrus.Fields{"monitor": mon.Name})
+	send := func(path string, body string) int {
+		rec := httptest.NewRecorder()
+		cfg.Heartbeat.ServeHTTP(rec, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := send("/ping/unknown", ""); code != http.StatusNotFound {
+		t.Errorf("unknown token should return 404, got %d", code)
+	}
+
+	if mon.test(l) {
+		t.Error("monitor should be down before any ping")
+	}
+
+	send("/ping/secret/start", "")
+	if code := send("/ping/secret", ""); code != http.StatusOK {
+		t.Fatalf("ping should return 200, got %d", code)
+	}
+	if !mon.test(l) {
+		t.Errorf("monitor should be up after a ping: %s", mon.lastFailReason)
+	}
+	if mon.customLag < 0 {
+		t.Error("runtime should be reported after a start/ping sequence")
+	}
+
+	mon.customLag = -1
+	mon.noLag = false
+	mon.test(l)
+	if !mon.noLag || mon.customLag >= 0 {
+		t.Error("runtime should only be reported once")
+	}
+
+	send("/ping/secret/fail", "disk full")
+	if mon.test(l) {
+		t.Error("monitor should be down after a failure ping")
+	}
+	if !strings.Contains(mon.lastFailReason, "disk full") {
+		t.Errorf("fail reason should contain the request body, got %q", mon.lastFailReason)
+	}
+}
+
+func TestHeartbeatMissedDeadline(t *testing.T) {
+	fake := cachettest.NewServer()
+	defer fake.Close()
+	component := fake.AddComponent(cachettest.Component{Name: "Backup", Enabled: true})
+
+	cfg := &CachetMonitor{
+		API:        CachetAPI{URL: fake.URL, Token: fake.Token, Retries: -1},
+		SystemName: "test",
+		DateFormat: DefaultTimeFormat,
+	}
+	mon := &HeartbeatMonitor{AbstractMonitor: AbstractMonitor{Name: "backup", ComponentID: component.ID, Interval: 86400, Enabled: true, ThresholdCount: 3, HistorySize: 3}, Token: "daily", Grace: 1800}
+	if errs := mon.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if !mon.Init(cfg) {
+		t.Fatal("expected the monitor to load its component")
+	}
+	defer cfg.Heartbeat.unregister(mon)
+
+	if interval := mon.checkInterval(); interval != time.Minute {
+		t.Errorf("expected a daily heartbeat to be checked every minute, got %v", interval)
+	}
+
+	// the last ping is a minute past the deadline: three checks (minutes) open the incident
+	mon.mu.Lock()
+	mon.lastSeen = time.Now().Add(-(86400 + 1800 + 60) * time.Second)
+	mon.mu.Unlock()
+
+	for i := 0; i < mon.HistorySize; i++ {
+		mon.tick(mon)
+	}
+	if incidents := fake.Incidents(); len(incidents) != 1 || incidents[0].ComponentID != component.ID {
+		t.Fatalf("expected an incident after %d checks, got %+v", mon.HistorySize, incidents)
+	}
+
+	mon.ping(false, "")
+	mon.tick(mon)
+	if !mon.history[len(mon.history)-1] {
+		t.Errorf("expected the check to pass after a ping: %s", mon.lastFailReason)
+	}
+}
@@ -124,6 +124,7 @@ func (monitor *HTTPMonitor) test(l *logrus.Entry) bool {
 	}
 
 	defer resp.Body.Close()
+	monitor.setDetail("status_code", resp.StatusCode)
 
 	if len(monitor.ExpectedStatusCode) > 0 && !contains(monitor.ExpectedStatusCode, resp.StatusCode) {
 		monitor.lastFailReason = "Expected HTTP response status: " + intToStr(monitor.ExpectedStatusCode) + ", got: " + strconv.Itoa(resp.StatusCode)
@@ -134,6 +135,7 @@ func (monitor *HTTPMonitor) test(l *logrus.Entry) bool {
 	monitor.setBodyRegexp(nil)
 
 	responseBody, err := ioutil.ReadAll(resp.Body)
+	monitor.setDetail("body_size", len(responseBody))
 
 	if monitor.bodyRegexp != nil {
 		if err != nil {
@@ -146,6 +148,7 @@ func (monitor *HTTPMonitor) test(l *logrus.Entry) bool {
 			l.Infof("HTTP response error: Unexpected body")
 			return false
 		}
+		monitor.setDetail("matched_body", monitor.internalBodyRegexp)
 	}
 
 	monitor.triggerShellHook(l, "on_success", monitor.ShellHookOnSuccess, string(responseBody))
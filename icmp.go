@@ -0,0 +1,269 @@
+package cachet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const DefaultICMPCount = 3
+
+const icmpProtocolV4 = 1
+const icmpProtocolV6 = 58
+
+type ICMPMonitor struct {
+	AbstractMonitor `mapstructure:",squash"`
+
+	// echo requests sent per tick
+	Count int
+
+	// % of lost packets above which the check fails
+	MaxPacketLoss int `mapstructure:"max_packet_loss"`
+	// average round trip time (in ms) above which the check fails (0 = disabled)
+	MaxRTT int `mapstructure:"max_rtt"`
+
+	seq int
+	// random payload identifying the replies to this monitor (its first bytes are the ID)
+	token []byte
+}
+
+// listenICMP opens an unprivileged datagram socket and falls back to a raw one
+func listenICMP(ip net.IP) (*icmp.PacketConn, bool, error) {
+	network, rawNetwork, address := "udp4", "ip4:icmp", "0.0.0.0"
+	if ip.To4() == nil {
+		network, rawNetwork, address = "udp6", "ip6:ipv6-icmp", "::"
+	}
+
+	conn, err := icmp.ListenPacket(network, address)
+	if err == nil {
+		return conn, false, nil
+	}
+
+	conn, err = icmp.ListenPacket(rawNetwork, address)
+	if err != nil {
+		return nil, true, err
+	}
+
+	return conn, true, nil
+}
+
+// icmpEcho is an echo request waiting for its reply
+type icmpEcho struct {
+	ip         net.IP
+	id         int
+	seq        int
+	data       []byte
+	privileged bool
+}
+
+// matches tells whether packet, received from peer, is the reply to the request. Every socket of the
+// process gets the echo replies of all the targets: the sender and the payload (unique per monitor)
+// tell them apart, as the ID is rewritten by the kernel on datagram sockets.
+func (echo *icmpEcho) matches(proto int, packet []byte, peer net.Addr) bool {
+	var peerIP net.IP
+	switch addr := peer.(type) {
+	case *net.UDPAddr:
+		peerIP = addr.IP
+	case *net.IPAddr:
+		peerIP = addr.IP
+	}
+	if !peerIP.Equal(echo.ip) {
+		return false
+	}
+
+	reply, err := icmp.ParseMessage(proto, packet)
+	if err != nil || (reply.Type != ipv4.ICMPTypeEchoReply && reply.Type != ipv6.ICMPTypeEchoReply) {
+		return false
+	}
+	body, ok := reply.Body.(*icmp.Echo)
+	if !ok || body.Seq != echo.seq || !bytes.Equal(body.Data, echo.data) {
+		return false
+	}
+
+	return !echo.privileged || body.ID == echo.id
+}
+
+// ping sends a single echo request and waits for the matching reply
+func (monitor *ICMPMonitor) ping(conn *icmp.PacketConn, ip net.IP, privileged bool) (time.Duration, error) {
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	if privileged {
+		dst = &net.IPAddr{IP: ip}
+	}
+
+	var msgType icmp.Type = ipv4.ICMPTypeEcho
+	proto := icmpProtocolV4
+	if ip.To4() == nil {
+		msgType = ipv6.ICMPTypeEchoRequest
+		proto = icmpProtocolV6
+	}
+
+	if len(monitor.token) == 0 {
+		monitor.token = make([]byte, 8)
+		if _, err := rand.Read(monitor.token); err != nil {
+			monitor.token = nil
+			return 0, err
+		}
+	}
+	monitor.seq = (monitor.seq + 1) & 0xffff
+
+	echo := &icmpEcho{
+		ip:         ip,
+		id:         int(binary.BigEndian.Uint16(monitor.token)),
+		seq:        monitor.seq,
+		data:       append([]byte("cachet-monitor "), monitor.token...),
+		privileged: privileged,
+	}
+	msg := icmp.Message{
+		Type: msgType,
+		Body: &icmp.Echo{
+			ID:   echo.id,
+			Seq:  echo.seq,
+			Data: echo.data,
+		},
+	}
+	payload, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	conn.SetDeadline(start.Add(time.Duration(monitor.Timeout * time.Second)))
+
+	if _, err := conn.WriteTo(payload, dst); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+
+		if echo.matches(proto, buf[:n], peer) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+func (monitor *ICMPMonitor) test(l *logrus.Entry) bool {
+	addr, err := net.ResolveIPAddr("ip", monitor.Target)
+	if err != nil {
+		monitor.lastFailReason = err.Error()
+		l.Infof("ICMP resolve failure: %s", monitor.lastFailReason)
+		return false
+	}
+
+	conn, privileged, err := listenICMP(addr.IP)
+	if err != nil {
+		monitor.lastFailReason = "Unable to open ICMP socket: " + err.Error()
+		l.Warnf("%s", monitor.lastFailReason)
+		return false
+	}
+	defer conn.Close()
+
+	l.Debugf("Privileged ICMP socket: %t", privileged)
+
+	received := 0
+	var total time.Duration
+	for i := 0; i < monitor.Count; i++ {
+		rtt, err := monitor.ping(conn, addr.IP, privileged)
+		if err != nil {
+			l.Debugf("ICMP echo #%d failed: %v", i+1, err)
+			continue
+		}
+		received++
+		total += rtt
+	}
+
+	summary, ok := monitor.checkReplies(l, received, total)
+	monitor.setDetail("address", addr.String())
+	if !ok {
+		return false
+	}
+
+	monitor.triggerShellHook(l, "on_success", monitor.ShellHookOnSuccess, summary)
+
+	return true
+}
+
+// checkReplies compares the packet loss and average round trip time of the echo requests
+// to the limits, returning a summary of the replies
+func (monitor *ICMPMonitor) checkReplies(l *logrus.Entry, received int, total time.Duration) (string, bool) {
+	loss := float32(monitor.Count-received) / float32(monitor.Count) * 100
+	avgRTT := float32(0)
+	if received > 0 {
+		avgRTT = float32(total) / float32(received) / float32(time.Millisecond)
+		monitor.customLag = int64(avgRTT + 0.5)
+	}
+
+	monitor.setDetail("packet_loss", loss)
+	monitor.setDetail("received", received)
+	monitor.setDetail("avg_rtt_ms", avgRTT)
+
+	summary := fmt.Sprintf("Packet loss: %.2f%% (%d/%d received), average RTT: %.2fms", loss, received, monitor.Count, avgRTT)
+	l.Debugf("%s", summary)
+
+	if received == 0 || int(loss) > monitor.MaxPacketLoss {
+		monitor.lastFailReason = summary + ".\nExpected packet loss <= " + strconv.Itoa(monitor.MaxPacketLoss) + "%"
+		l.Infof("ICMP check failure: %s", summary)
+		return summary, false
+	}
+
+	if monitor.MaxRTT > 0 && avgRTT > float32(monitor.MaxRTT) {
+		monitor.lastFailReason = summary + ".\nExpected average RTT <= " + strconv.Itoa(monitor.MaxRTT) + "ms"
+		l.Infof("ICMP check failure: %s", summary)
+		return summary, false
+	}
+
+	return summary, true
+}
+
+func (mon *ICMPMonitor) Validate() []string {
+	mon.Template.Investigating.SetDefault(defaultHTTPInvestigatingTpl)
+	mon.Template.Fixed.SetDefault(defaultHTTPFixedTpl)
+
+	errs := mon.AbstractMonitor.Validate()
+
+	if len(mon.Target) == 0 {
+		errs = append(errs, "'Target' has not been set")
+	}
+
+	if mon.Count <= 0 {
+		mon.Count = DefaultICMPCount
+	}
+
+	if time.Duration(mon.Count)*mon.Timeout > mon.Interval {
+		errs = append(errs, "count * timeout greater than interval")
+	}
+
+	if mon.MaxPacketLoss < 0 || mon.MaxPacketLoss > 100 {
+		errs = append(errs, "'max_packet_loss' must be a percentage between 0 and 100")
+	}
+
+	if mon.MaxRTT < 0 {
+		mon.MaxRTT = 0
+	}
+
+	return errs
+}
+
+func (mon *ICMPMonitor) Describe() []string {
+	features := mon.AbstractMonitor.Describe()
+	features = append(features, "Echo requests: "+strconv.Itoa(mon.Count))
+	features = append(features, "Max packet loss: "+strconv.Itoa(mon.MaxPacketLoss)+"%")
+	if mon.MaxRTT > 0 {
+		features = append(features, "Max average RTT: "+strconv.Itoa(mon.MaxRTT)+"ms")
+	}
+
+	return features
+}
@@ -0,0 +1,97 @@
+package cachet
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func echoPacket(t *testing.T, msgType icmp.Type, id, seq int, data string) []byte {
+	packet, err := (&icmp.Message{Type: msgType, Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte(data)}}).Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return packet
+}
+
+func TestICMPEchoMatches(t *testing.T) {
+	target := net.ParseIP("192.0.2.10")
+	echo := &icmpEcho{ip: target, id: 0x1234, seq: 7, data: []byte("cachet-monitor token-a")}
+	peer := &net.UDPAddr{IP: target}
+
+	tests := []struct {
+		name     string
+		packet   []byte
+		peer     net.Addr
+		expected bool
+	}{
+		// datagram sockets: the kernel rewrites the ID
+		{"reply", echoPacket(t, ipv4.ICMPTypeEchoReply, 0x9999, 7, "cachet-monitor token-a"), peer, true},
+		{"other target", echoPacket(t, ipv4.ICMPTypeEchoReply, 0x1234, 7, "cachet-monitor token-a"), &net.UDPAddr{IP: net.ParseIP("192.0.2.11")}, false},
+		{"other monitor", echoPacket(t, ipv4.ICMPTypeEchoReply, 0x1234, 7, "cachet-monitor token-b"), peer, false},
+		{"previous sequence", echoPacket(t, ipv4.ICMPTypeEchoReply, 0x1234, 6, "cachet-monitor token-a"), peer, false},
+		{"request", echoPacket(t, ipv4.ICMPTypeEcho, 0x1234, 7, "cachet-monitor token-a"), peer, false},
+		{"garbage", []byte{0, 1}, peer, false},
+	}
+
+	for _, test := range tests {
+		if matches := echo.matches(icmpProtocolV4, test.packet, test.peer); matches != test.expected {
+			t.Errorf("%s: expected %t, got %t", test.name, test.expected, matches)
+		}
+	}
+
+	// raw sockets keep the ID
+	echo.privileged = true
+	if echo.matches(icmpProtocolV4, echoPacket(t, ipv4.ICMPTypeEchoReply, 0x9999, 7, "cachet-monitor token-a"), &net.IPAddr{IP: target}) {
+		t.Error("expected a reply with another ID not to match on a raw socket")
+	}
+	if !echo.matches(icmpProtocolV4, echoPacket(t, ipv4.ICMPTypeEchoReply, 0x1234, 7, "cachet-monitor token-a"), &net.IPAddr{IP: target}) {
+		t.Error("expected the reply to match on a raw socket")
+	}
+
+	echo6 := &icmpEcho{ip: net.ParseIP("2001:db8::1"), seq: 1, data: []byte("cachet-monitor token-a")}
+	if !echo6.matches(icmpProtocolV6, echoPacket(t, ipv6.ICMPTypeEchoReply, 1, 1, "cachet-monitor token-a"), &net.UDPAddr{IP: echo6.ip}) {
+		t.Error("expected the IPv6 reply to match")
+	}
+}
+
+func TestICMPCheckReplies(t *testing.T) {
+	l := logrus.WithFields(logrus.Fields{"monitor": "icmp"})
+
+	tests := []struct {
+		maxLoss  int
+		maxRTT   int
+		received int
+		total    time.Duration
+		up       bool
+		reason   string
+	}{
+		{25, 0, 3, 30 * time.Millisecond, true, ""},
+		{25, 0, 2, 20 * time.Millisecond, false, "Expected packet loss <= 25%"},
+		// no reply at all is always a failure
+		{100, 0, 0, 0, false, "Expected packet loss <= 100%"},
+		{0, 50, 4, 240 * time.Millisecond, false, "Expected average RTT <= 50ms"},
+		{0, 50, 4, 160 * time.Millisecond, true, ""},
+	}
+
+	for _, test := range tests {
+		mon := &ICMPMonitor{AbstractMonitor: AbstractMonitor{Name: "icmp"}, Count: 4, MaxPacketLoss: test.maxLoss, MaxRTT: test.maxRTT}
+		_, up := mon.checkReplies(l, test.received, test.total)
+		if up != test.up || !strings.HasSuffix(mon.lastFailReason, test.reason) {
+			t.Errorf("%d/4 received in %v: expected up=%t (%q), got up=%t (%q)", test.received, test.total, test.up, test.reason, up, mon.lastFailReason)
+		}
+	}
+
+	mon := &ICMPMonitor{Count: 4, MaxPacketLoss: 50}
+	mon.checkReplies(l, 2, 25*time.Millisecond)
+	if mon.customLag != 13 {
+		t.Errorf("expected the average RTT as response time, got %dms", mon.customLag)
+	}
+}
@@ -1,6 +1,7 @@
 package cachet
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"strconv"
@@ -12,7 +13,7 @@ type Incident struct {
 	Name    string `json:"name"`
 	Message string `json:"message"`
 	Status  int    `json:"status"`
-	Visible int    `json"visible"`
+	Visible int    `json:"visible,omitempty"`
 	Notify  bool   `json:"notify"`
 
 	ComponentID     int `json:"component_id"`
@@ -20,19 +21,27 @@ type Incident struct {
 }
 
 // Send - Create or Update incident
-func (incident *Incident) Send(cfg *CachetMonitor) error {
+func (incident *Incident) Send(ctx context.Context, cfg *CachetMonitor) error {
 	switch incident.Status {
-		case 1, 2, 3:
+		case 1, 2:
+			if incident.ComponentStatus == 2 {
+				// performance issues are not escalated
+				break
+			}
+
 			// partial outage
 			incident.ComponentStatus = 3
 
-			compInfo := cfg.API.GetComponentData(incident.ComponentID)
-			if compInfo.Status == 3 {
-				// major outage
+			compInfo, err := cfg.API.GetComponentData(ctx, incident.ComponentID)
+			if err != nil {
+				return err
+			}
+			if compInfo.Status == 3 || compInfo.Status == 4 {
+				// major outage (kept when an open incident is identified)
 				incident.ComponentStatus = 4
 			}
-		case 4:
-			// fixed
+		case 3, 4:
+			// watching / fixed
 			incident.ComponentStatus = 1
 	}
 
@@ -45,7 +54,7 @@ func (incident *Incident) Send(cfg *CachetMonitor) error {
 
 	jsonBytes, _ := json.Marshal(incident)
 
-	resp, body, err := cfg.API.NewRequest(requestType, requestURL, jsonBytes)
+	_, body, err := cfg.API.NewRequest(ctx, requestType, requestURL, jsonBytes)
 	if err != nil {
 		return err
 	}
@@ -58,13 +67,26 @@ func (incident *Incident) Send(cfg *CachetMonitor) error {
 	}
 
 	incident.ID = data.ID
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("Could not create/update incident!")
-	}
 
 	return nil
 }
 
+// PostUpdate - Adds an update to the incident (keeps its current status)
+func (incident *Incident) PostUpdate(ctx context.Context, cfg *CachetMonitor, message string) error {
+	if incident.ID == 0 {
+		return fmt.Errorf("Cannot update an incident which has not been created")
+	}
+
+	jsonBytes, _ := json.Marshal(map[string]interface{}{
+		"status":  incident.Status,
+		"message": message,
+	})
+
+	_, _, err := cfg.API.NewRequest(ctx, "POST", "/incidents/"+strconv.Itoa(incident.ID)+"/updates", jsonBytes)
+
+	return err
+}
+
 // SetInvestigating sets status to Investigating
 func (incident *Incident) SetInvestigating() {
 	incident.Status = 1
@@ -84,3 +106,13 @@ func (incident *Incident) SetWatching() {
 func (incident *Incident) SetFixed() {
 	incident.Status = 4
 }
+
+// IsInvestigating returns true when status is Investigating
+func (incident *Incident) IsInvestigating() bool {
+	return incident.Status == 1
+}
+
+// IsWatching returns true when status is Watching
+func (incident *Incident) IsWatching() bool {
+	return incident.Status == 3
+}
@@ -0,0 +1,121 @@
+package cachet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cachet/cachettest"
+)
+
+// flakyTarget is a web server whose availability is switched by the test
+type flakyTarget struct {
+	mu   sync.Mutex
+	down bool
+}
+
+func (target *flakyTarget) setDown(down bool) {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	target.down = down
+}
+
+func (target *flakyTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target.mu.Lock()
+	defer target.mu.Unlock()
+	if target.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func TestIncidentFlow(t *testing.T) {
+	fake := cachettest.NewServer()
+	defer fake.Close()
+	component := fake.AddComponent(cachettest.Component{Name: "Website", Enabled: true})
+
+	target := &flakyTarget{}
+	srv := httptest.NewServer(target)
+	defer srv.Close()
+
+	cfg := &CachetMonitor{
+		API:        CachetAPI{URL: fake.URL, Token: fake.Token, Retries: -1, retryWait: time.Millisecond},
+		SystemName: "test",
+		DateFormat: DefaultTimeFormat,
+	}
+	newMonitor := func() *HTTPMonitor {
+		mon := &HTTPMonitor{
+			AbstractMonitor:    AbstractMonitor{Name: "web", Target: srv.URL, ComponentID: component.ID, ThresholdCount: 2, HistorySize: 3},
+			ExpectedStatusCode: []int{200},
+		}
+		mon.Metrics.ResponseTime = []int{5}
+		if errs := mon.Validate(); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if !mon.Init(cfg) {
+			t.Fatal("expected the monitor to load its component")
+		}
+		return mon
+	}
+	mon := newMonitor()
+
+	mon.tick(mon)
+	mon.tick(mon)
+	if incidents := fake.Incidents(); len(incidents) > 0 {
+		t.Fatalf("expected no incident while up, got %+v", incidents)
+	}
+
+	// the incident cannot be created on the first outage tick
+	target.setDown(true)
+	fake.Inject(cachettest.Fault{Method: "POST", Path: "/incidents", Status: http.StatusInternalServerError, Times: 1})
+	mon.tick(mon)
+	mon.tick(mon)
+	if incidents := fake.Incidents(); len(incidents) > 0 || mon.incident != nil {
+		t.Fatalf("expected the failed incident to be retried, got %+v", incidents)
+	}
+
+	mon.tick(mon)
+	incidents := fake.Incidents()
+	if len(incidents) != 1 || incidents[0].Status != 1 || !strings.Contains(incidents[0].Name, "web") {
+		t.Fatalf("expected an investigating incident, got %+v", incidents)
+	}
+	if c, _ := fake.Component(component.ID); c.Status != 4 {
+		t.Errorf("expected a major outage, got status %d", c.Status)
+	}
+
+	// a restarted monitor picks the open incident up
+	if restarted := newMonitor(); restarted.incident == nil || restarted.incident.ID != incidents[0].ID {
+		t.Errorf("expected the open incident %d to be loaded, got %+v", incidents[0].ID, restarted.incident)
+	}
+
+	// a malformed response keeps the current incident
+	fake.Inject(cachettest.Fault{Method: "GET", Path: "/components", Malformed: true, Times: 1})
+	if err := mon.ReloadCachetData(); err == nil || mon.incident == nil {
+		t.Errorf("expected a parse error keeping the incident, got %v", err)
+	}
+
+	target.setDown(false)
+	mon.tick(mon)
+	if incidents := fake.Incidents(); incidents[0].Status != 1 {
+		t.Errorf("expected the incident to stay open below the recovery, got status %d", incidents[0].Status)
+	}
+	mon.tick(mon)
+	incidents = fake.Incidents()
+	if len(incidents) != 1 || incidents[0].Status != 4 || mon.incident != nil {
+		t.Errorf("expected the incident to be fixed, got %+v", incidents)
+	}
+	if c, _ := fake.Component(component.ID); c.Status != 1 {
+		t.Errorf("expected the component to be operational, got status %d", c.Status)
+	}
+
+	// response times are sent in the background
+	deadline := time.Now().Add(time.Second)
+	for len(fake.MetricPoints(5)) < 7 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if points := fake.MetricPoints(5); len(points) != 7 {
+		t.Errorf("expected a response time point per tick, got %d", len(points))
+	}
+}
@@ -0,0 +1,309 @@
+package cachet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// how often Cachet scheduled maintenance is fetched
+const maintenanceRefreshInterval = 60 * time.Second
+
+// date format of the Cachet API
+const cachetDateFormat = "2006-01-02 15:04:05"
+
+// MaintenanceWindow is a recurring window, starting at each time matched by Cron
+type MaintenanceWindow struct {
+	// minute hour day-of-month month day-of-week (local time)
+	Cron string
+	// seconds
+	Duration time.Duration
+
+	schedule *cronSchedule
+}
+
+// Schedule Cachet data model (scheduled maintenance)
+type Schedule struct {
+	ID          int                 `json:"id"`
+	Name        string              `json:"name"`
+	Status      int                 `json:"status"`
+	ScheduledAt string              `json:"scheduled_at"`
+	CompletedAt string              `json:"completed_at"`
+	Components  []ScheduleComponent `json:"components"`
+}
+
+type ScheduleComponent struct {
+	ID          int `json:"id"`
+	ComponentID int `json:"component_id"`
+}
+
+// cronSchedule holds the allowed values of each field as bitsets
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+// parseCronField parses *, a, a-b, with an optional /step, as a comma separated list
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, errors.New("invalid step in '" + part + "'")
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.New("invalid value '" + part + "'")
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.New("invalid range '" + part + "'")
+				}
+			} else if step > 1 {
+				// a/step means from a to max
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return 0, errors.New("'" + part + "' out of range " + strconv.Itoa(min) + "-" + strconv.Itoa(max))
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, errors.New("cron expression '" + expr + "' must have 5 fields")
+	}
+
+	var err error
+	c := &cronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	if c.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// 7 is sunday as well
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+func (c *cronSchedule) matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	// like cron, either day field matches when both are restricted
+	if !c.domStar && !c.dowStar {
+		return domMatch || dowMatch
+	}
+
+	return domMatch && dowMatch
+}
+
+// active tells whether t falls in a window started at a time matched by the cron expression
+func (window *MaintenanceWindow) active(t time.Time) bool {
+	if window.schedule == nil {
+		return false
+	}
+
+	duration := window.Duration * time.Second
+	for start := t.Truncate(time.Minute); t.Sub(start) < duration; start = start.Add(-time.Minute) {
+		if window.schedule.matches(start) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// active tells whether the scheduled maintenance is in progress or between its scheduled and completed dates
+func (schedule *Schedule) active(now time.Time) bool {
+	switch schedule.Status {
+	case 1:
+		// in progress
+		return true
+	case 2:
+		// complete
+		return false
+	}
+
+	scheduledAt, err := time.ParseInLocation(cachetDateFormat, schedule.ScheduledAt, time.Local)
+	if err != nil || now.Before(scheduledAt) {
+		return false
+	}
+	// upcoming maintenance without an end is only active once set in progress
+	completedAt, err := time.ParseInLocation(cachetDateFormat, schedule.CompletedAt, time.Local)
+
+	return err == nil && now.Before(completedAt)
+}
+
+func (schedule *Schedule) hasComponent(componentID int) bool {
+	for _, component := range schedule.Components {
+		id := component.ComponentID
+		if id == 0 {
+			id = component.ID
+		}
+		if id == componentID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetSchedules returns the latest scheduled maintenance
+func (api *CachetAPI) GetSchedules(ctx context.Context) ([]Schedule, error) {
+	query := url.Values{}
+	query.Set("sort", "scheduled_at")
+	query.Set("order", "desc")
+	query.Set("per_page", "50")
+
+	_, body, err := api.NewRequest(ctx, "GET", "/schedules?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := []Schedule{}
+	err = json.Unmarshal(body.Data, &schedules)
+
+	return schedules, err
+}
+
+// cachetMaintenanceActive refreshes the Cachet scheduled maintenance at most every maintenanceRefreshInterval
+func (mon *AbstractMonitor) cachetMaintenanceActive(l *logrus.Entry) bool {
+	if mon.now().Sub(mon.schedulesFetched) >= maintenanceRefreshInterval {
+		schedules, err := mon.config.API.GetSchedules(mon.apiContext())
+		if err != nil {
+			l.Warnf("Could not get scheduled maintenance: %v", err)
+		} else {
+			mon.schedulesFetched = mon.now()
+			mon.cachetMaintenance = nil
+			for i := range schedules {
+				if schedules[i].hasComponent(mon.ComponentID) {
+					mon.cachetMaintenance = append(mon.cachetMaintenance, schedules[i])
+				}
+			}
+		}
+	}
+
+	now := mon.now()
+	for i := range mon.cachetMaintenance {
+		if mon.cachetMaintenance[i].active(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maintenanceReason returns the active maintenance window, or an empty string
+func (mon *AbstractMonitor) maintenanceReason(l *logrus.Entry) string {
+	now := mon.now()
+	for _, window := range mon.Maintenance {
+		if window.active(now) {
+			return "maintenance window '" + window.Cron + "'"
+		}
+	}
+
+	if mon.MaintenanceFromCachet && mon.cachetMaintenanceActive(l) {
+		return "Cachet scheduled maintenance"
+	}
+
+	return ""
+}
+
+// updateMaintenance returns true while in maintenance, when incidents and component status must be left alone
+func (mon *AbstractMonitor) updateMaintenance(l *logrus.Entry) bool {
+	if len(mon.Maintenance) == 0 && !mon.MaintenanceFromCachet {
+		return false
+	}
+
+	reason := mon.maintenanceReason(l)
+	if len(reason) > 0 {
+		if !mon.inMaintenance {
+			l.Infof("Entering %s", reason)
+			mon.inMaintenance = true
+			if mon.MaintenanceStatus > 0 {
+				mon.maintenanceRestoreStatus = mon.currentStatus
+				mon.setComponentStatus(l, mon.MaintenanceStatus)
+			}
+		}
+		return true
+	}
+
+	if mon.inMaintenance {
+		l.Infof("Maintenance is over")
+		mon.inMaintenance = false
+		if mon.MaintenanceStatus > 0 && mon.maintenanceRestoreStatus > 0 {
+			mon.setComponentStatus(l, mon.maintenanceRestoreStatus)
+		}
+
+		// failures recorded during the maintenance must not trigger an incident now, but the history
+		// stays saturated so that a target still down is reported on the usual threshold
+		mon.resetHistory()
+	}
+
+	return false
+}
+
+// resetHistory replaces the history with successful checks, but the last one
+func (mon *AbstractMonitor) resetHistory() {
+	size := mon.HistorySize
+	if size < 1 {
+		size = 1
+	}
+
+	history := make([]bool, size)
+	warningHistory := make([]bool, size)
+	for i := range history {
+		history[i] = true
+	}
+	if last := len(mon.history) - 1; last >= 0 {
+		history[size-1] = mon.history[last]
+		if last < len(mon.warningHistory) {
+			warningHistory[size-1] = mon.warningHistory[last]
+		}
+	}
+
+	mon.history = history
+	mon.warningHistory = warningHistory
+}
@@ -0,0 +1,184 @@
+package cachet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"cachet/cachettest"
+)
+
+func TestParseCron(t *testing.T) {
+	// every tuesday at 22:00
+	c, err := parseCron("0 22 * * 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuesday := time.Date(2018, 3, 6, 22, 0, 0, 0, time.Local)
+	if !c.matches(tuesday) {
+		t.Error("expected tuesday 22:00 to match")
+	}
+	if c.matches(tuesday.Add(time.Minute)) || c.matches(tuesday.AddDate(0, 0, 1)) {
+		t.Error("expected only tuesday 22:00 to match")
+	}
+
+	c, err = parseCron("*/15 1-3 1,15 * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// day of month or day of week when both are restricted (2018-03-04 is a sunday)
+	if !c.matches(time.Date(2018, 3, 4, 2, 45, 0, 0, time.Local)) || !c.matches(time.Date(2018, 3, 15, 1, 0, 0, 0, time.Local)) {
+		t.Error("expected sunday and the 15th to match")
+	}
+	if c.matches(time.Date(2018, 3, 15, 1, 10, 0, 0, time.Local)) {
+		t.Error("expected only every 15 minutes to match")
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("expected %q to be invalid", expr)
+		}
+	}
+}
+
+func TestMaintenanceWindowActive(t *testing.T) {
+	window := MaintenanceWindow{Cron: "0 22 * * 2", Duration: 2 * 3600}
+	window.schedule, _ = parseCron(window.Cron)
+
+	start := time.Date(2018, 3, 6, 22, 0, 0, 0, time.Local)
+	if !window.active(start) || !window.active(start.Add(119*time.Minute)) {
+		t.Error("expected the window to be active for 2 hours")
+	}
+	if window.active(start.Add(-time.Minute)) || window.active(start.Add(2*time.Hour)) {
+		t.Error("expected the window to be inactive outside of the 2 hours")
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	now := time.Date(2018, 3, 6, 22, 30, 0, 0, time.Local)
+	schedule := Schedule{
+		ScheduledAt: "2018-03-06 22:00:00",
+		CompletedAt: "2018-03-06 23:00:00",
+		Components:  []ScheduleComponent{{ID: 9, ComponentID: 3}},
+	}
+	if !schedule.active(now) || schedule.active(now.Add(time.Hour)) {
+		t.Error("expected the schedule to be active between its dates")
+	}
+	if schedule.Status = 2; schedule.active(now) {
+		t.Error("expected a complete schedule to be inactive")
+	}
+	if !schedule.hasComponent(3) || schedule.hasComponent(9) {
+		t.Error("expected schedule components to be matched by component_id")
+	}
+}
+
+func TestUpdateMaintenance(t *testing.T) {
+	l := logrus.WithFields(logrus.Fields{})
+	mon := &AbstractMonitor{HistorySize: 3, Maintenance: []MaintenanceWindow{{Cron: "* * * * *", Duration: 60}}}
+	mon.Maintenance[0].schedule, _ = parseCron(mon.Maintenance[0].Cron)
+	mon.history = []bool{false, false, false}
+	mon.warningHistory = []bool{false, true, true}
+
+	if !mon.updateMaintenance(l) || !mon.inMaintenance {
+		t.Fatal("expected to be in maintenance")
+	}
+
+	mon.Maintenance = []MaintenanceWindow{{Cron: "0 0 31 2 *", Duration: 60}}
+	mon.Maintenance[0].schedule, _ = parseCron(mon.Maintenance[0].Cron)
+	if mon.updateMaintenance(l) || mon.inMaintenance {
+		t.Fatal("expected the maintenance to be over")
+	}
+	if len(mon.history) != 3 || !mon.history[0] || !mon.history[1] || mon.history[2] {
+		t.Errorf("failures recorded during maintenance should be discarded but the last check, got %v", mon.history)
+	}
+	if mon.warningHistory[0] || mon.warningHistory[1] || !mon.warningHistory[2] {
+		t.Errorf("expected the warning of the last check to be kept, got %v", mon.warningHistory)
+	}
+}
+
+func TestMaintenanceMetrics(t *testing.T) {
+	fake := cachettest.NewServer()
+	defer fake.Close()
+	component := fake.AddComponent(cachettest.Component{Name: "Website", Enabled: true})
+
+	cfg := &CachetMonitor{
+		API:        CachetAPI{URL: fake.URL, Token: fake.Token, Retries: -1},
+		SystemName: "test",
+		DateFormat: DefaultTimeFormat,
+	}
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{
+		Name:           "web",
+		ComponentID:    component.ID,
+		Enabled:        true,
+		ThresholdCount: 2,
+		HistorySize:    3,
+		Maintenance:    []MaintenanceWindow{{Cron: "* * * * *", Duration: 60}},
+	}}
+	mon.Metrics.Availability = []int{7}
+	mon.Metrics.IncidentCount = []int{8}
+	if errs := mon.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if !mon.Init(cfg) {
+		t.Fatal("expected the monitor to load its component")
+	}
+
+	// failures from before the window still reach the threshold
+	mon.history = []bool{false, false}
+	mon.warningHistory = []bool{false, false}
+	for i := 0; i < 3; i++ {
+		mon.tick(mon)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for (len(fake.MetricPoints(7)) == 0 || len(fake.MetricPoints(8)) == 0) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if availability, incidentCount := fake.MetricPoints(7), fake.MetricPoints(8); len(availability) != 1 || len(incidentCount) != 1 {
+		t.Errorf("expected metrics to be recorded during maintenance, got %d availability and %d incident count points", len(availability), len(incidentCount))
+	}
+	if incidents := fake.Incidents(); len(incidents) > 0 {
+		t.Errorf("expected no incident during maintenance, got %+v", incidents)
+	}
+}
+
+func TestMaintenanceStillDown(t *testing.T) {
+	fake := cachettest.NewServer()
+	defer fake.Close()
+	component := fake.AddComponent(cachettest.Component{Name: "Website", Enabled: true})
+
+	current := time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local)
+	cfg := &CachetMonitor{
+		API:        CachetAPI{URL: fake.URL, Token: fake.Token, Retries: -1},
+		SystemName: "test",
+		DateFormat: DefaultTimeFormat,
+		clock:      func() time.Time { return current },
+	}
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{
+		Name:           "web",
+		ComponentID:    component.ID,
+		ThresholdCount: 2,
+		HistorySize:    5,
+		// 00:00 to 00:03
+		Maintenance: []MaintenanceWindow{{Cron: "0 0 * * *", Duration: 180}},
+	}}
+	if errs := mon.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if !mon.Init(cfg) {
+		t.Fatal("expected the monitor to load its component")
+	}
+
+	l := logrus.WithFields(logrus.Fields{"monitor": mon.Name})
+	for minute := 0; minute < 5; minute++ {
+		current = time.Date(2026, 1, 1, 0, minute, 0, 0, time.Local)
+		mon.recordCheck(l, false, 0)
+
+		incidents := fake.Incidents()
+		// the target is still down after the window: the 2nd failure reaches the threshold
+		if expected := minute == 4; (len(incidents) > 0) != expected {
+			t.Fatalf("%02d:00: expected incident=%t, got %+v", minute, expected, incidents)
+		}
+	}
+}
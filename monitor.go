@@ -1,9 +1,11 @@
 package cachet
 
 import (
+	"context"
 	"sync"
 	"time"
 	"strconv"
+	"strings"
 	"os/exec"
 
 	"github.com/Sirupsen/logrus"
@@ -13,12 +15,48 @@ const DefaultInterval = time.Second * 60
 const DefaultTimeout = time.Second
 const DefaultTimeFormat = "15:04:05 Jan 2 MST"
 const DefaultHistorySize = 10
+const DefaultFailReasonsSize = 5
+const DefaultFlapThresholdHigh = 50
+const DefaultFlapThresholdLow = 25
+
+// Identified template
+var defaultIdentifiedTpl = MessageTemplate{
+	Subject: `{{ .Monitor.Name }} - {{ .SystemName }}`,
+	Message: `{{ .Monitor.Name }} outage **identified** (down for {{ .Duration }}, server time: {{ .now }})
+
+{{ .FailReason }}`,
+}
+
+// Watching template
+var defaultWatchingTpl = MessageTemplate{
+	Subject: `{{ .Monitor.Name }} - {{ .SystemName }}`,
+	Message: `**Watching** - {{ .now }}
+
+{{ .Monitor.Name }} has recovered after {{ .Duration }} and is being monitored.`,
+}
+
+// Update template (posted as an incident update during long outages)
+var defaultUpdateTpl = MessageTemplate{
+	Subject: `{{ .Monitor.Name }} - {{ .SystemName }}`,
+	Message: `{{ .Monitor.Name }} is still **down** (for {{ .Duration }}, {{ .DownCount }} failed checks, server time: {{ .now }})
+
+{{ .FailReason }}`,
+}
+
+// Flapping template (posted as an incident update)
+var defaultFlappingTpl = MessageTemplate{
+	Subject: `{{ .Monitor.Name }} - {{ .SystemName }}`,
+	Message: `{{ .Monitor.Name }} is **flapping** (state change: {{ printf "%.0f" .StateChange }}%, server time: {{ .now }})
+
+{{ .FailReason }}`,
+}
 
 type MonitorInterface interface {
 	ClockStart(*CachetMonitor, MonitorInterface, *sync.WaitGroup)
 	ClockStop()
 	tick(MonitorInterface)
 	test(l *logrus.Entry) bool
+	checkInterval() time.Duration
 
 	Init(*CachetMonitor) bool
 	Validate() []string
@@ -26,6 +64,12 @@ type MonitorInterface interface {
 	Describe() []string
 }
 
+type metricPoint struct {
+	name  string
+	ids   []int
+	value float64
+}
+
 // AbstractMonitor data model
 type AbstractMonitor struct {
 	Name   string
@@ -42,14 +86,21 @@ type AbstractMonitor struct {
 
 	MetricID    int `mapstructure:"metric_id"`
 	ComponentID int `mapstructure:"component_id"`
+	// component referenced by name instead of component_id, see CachetMonitor.Provision
+	Component ComponentRef
 
 	// Metric stuff
 	Metrics struct {
 		ResponseTime []int	`mapstructure:"response_time"`
 		Availability []int	`mapstructure:"availability"`
 		IncidentCount []int	`mapstructure:"incident_count"`
+		// tls only: days until the certificate expires
+		DaysRemaining []int	`mapstructure:"days_remaining"`
 	}
 
+	// metrics referenced by name, by kind (see SplitMetricRefs)
+	MetricRefs map[string][]MetricRef `mapstructure:"-"`
+
 	// ShellHook stuff
 	ShellHookOnSuccess string	`mapstructure:"on_success"`
 	ShellHookOnFailure string	`mapstructure:"on_failure"`
@@ -57,7 +108,12 @@ type AbstractMonitor struct {
 	// Templating stuff
 	Template struct {
 		Investigating MessageTemplate
+		Identified    MessageTemplate
+		Watching      MessageTemplate
 		Fixed         MessageTemplate
+		Update        MessageTemplate
+		Flapping      MessageTemplate
+		Dependency    MessageTemplate
 	}
 
 	// Threshold = percentage / number of down incidents
@@ -72,22 +128,109 @@ type AbstractMonitor struct {
 	PartialThreshold      int `mapstructure:"threshold_partial"`
 	PartialThresholdCount int `mapstructure:"threshold_partial_count"`
 
-	// lag / average(lagHistory) * 100 = percentage above average lag
+	// consecutive successful checks required before resolving an incident
+	RecoveryCount int `mapstructure:"recovery_count"`
+	// % of downtime at or below which an incident can be resolved
+	RecoveryThreshold int `mapstructure:"recovery_threshold"`
+
+	// seconds of continuous outage before the incident moves to Identified (0 = never)
+	IdentifiedAfter time.Duration `mapstructure:"identified_after"`
+	// seconds the incident stays in Watching after recovery before being Fixed (0 = resolve immediately)
+	WatchingPeriod time.Duration `mapstructure:"watching_period"`
+
+	// seconds between incident updates while an incident is open (0 = disabled)
+	UpdateEvery time.Duration `mapstructure:"update_every"`
+	// number of recent fail reasons available to the update template
+	FailReasonsSize int `mapstructure:"fail_reasons_size"`
+
+	// Nagios-style flap detection: weighted % of state changes in history
+	FlapDetection     bool `mapstructure:"flap_detection"`
+	FlapThresholdHigh int  `mapstructure:"flap_threshold_high"`
+	FlapThresholdLow  int  `mapstructure:"flap_threshold_low"`
+
+	// lag / baseline(lagHistory) * 100 = percentage above baseline lag
 	// PerformanceThreshold sets the % limit above which this monitor will trigger degraded-performance
-	// PerformanceThreshold float32
+	PerformanceThreshold int `mapstructure:"performance_threshold"`
+	// PerformanceThresholdMs sets the absolute limit (in ms) above which this monitor will trigger degraded-performance
+	PerformanceThresholdMs int `mapstructure:"performance_threshold_ms"`
+	// number of successful checks the statistic is computed over (defaults to history_size)
+	PerformanceWindow int `mapstructure:"performance_window"`
+	// median (default) / p95
+	PerformanceStat string `mapstructure:"performance_stat"`
+	// open an incident on degraded-performance as well
+	PerformanceIncident bool `mapstructure:"performance_incident"`
+
+	// names of the monitors this one depends on: no incident is created while one of them is in incident
+	DependsOn []string `mapstructure:"depends_on"`
+	// note on the parent incident that this monitor is affected too
+	DependencyNote bool `mapstructure:"dependency_note"`
+
+	// recurring maintenance windows during which no incident is created
+	Maintenance []MaintenanceWindow
+	// honour the Cachet scheduled maintenance of the component as well
+	MaintenanceFromCachet bool `mapstructure:"maintenance_from_cachet"`
+	// component status set during maintenance and restored afterwards (0 = unchanged)
+	MaintenanceStatus int `mapstructure:"maintenance_status"`
 
 	resyncMod	int
 	currentStatus	int
 	currentDownCount int
 	currentUpCount	int
 	history		[]bool
-	// lagHistory   []float32
+	// true when the matching history entry failed with a warning only
+	warningHistory	[]bool
+	lagHistory	[]int64
+	lagBaseline	float32
+	lastLag		int64
+	performanceDegraded	bool
+	flapping	bool
+	incidentStart	time.Time
+	watchingSince	time.Time
+	lastIncidentUpdate	time.Time
+	lastStateSave	time.Time
+	lastTick	time.Time
+	// paused from the admin API, takes precedence over the component state
+	paused		bool
+	inMaintenance	bool
+	maintenanceRestoreStatus	int
+	schedulesFetched	time.Time
+	cachetMaintenance	[]Schedule
+	notedParentIncident	int
+	// location writing the status and incidents when running with peers
+	peerLeader	string
+	// fewer locations than the quorum report this monitor
+	peerDegraded	bool
+	failReasons	[]string
+	stateChange	float32
+	incidentIsPerformance	bool
 	lastFailReason	string
+	// set by implementations measuring their own response time (-1 = use tick lag)
+	customLag	int64
+	// set by implementations having no response time to report for this tick
+	noLag		bool
+	// set by implementations when a failed check should only count as a partial outage
+	warning		bool
+	// extra metric points collected during test, sent by tick
+	metricPoints	[]metricPoint
+	// what the last test observed (status code, answers...), reported by the check command
+	details	map[string]interface{}
+	// run by the check command: no shell hooks
+	checkOnly	bool
 	incident       	*Incident
 	config         	*CachetMonitor
 
 	// Closed when mon.Stop() is called
 	stopC chan bool
+	// Closed when the clock loop returns
+	doneC chan bool
+	// functions run between two ticks (admin API actions)
+	commandC chan func()
+	// status published after each tick for the admin API
+	statusMu sync.Mutex
+	status   MonitorStatus
+	// Cancelled when mon.Stop() is called, aborts pending API calls
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (mon *AbstractMonitor) Validate() []string {
@@ -108,9 +251,10 @@ func (mon *AbstractMonitor) Validate() []string {
 		errs = append(errs, "Timeout greater than interval")
 	}
 
-	if mon.ComponentID == 0 && mon.MetricID == 0 {
+	if mon.ComponentID == 0 && mon.MetricID == 0 && len(mon.Component.Name) == 0 {
 		errs = append(errs, "component_id & metric_id are unset")
 	}
+	errs = append(errs, mon.validateRefs()...)
 
 	if mon.HistorySize <= 0 {
 		mon.HistorySize = DefaultHistorySize
@@ -144,12 +288,109 @@ func (mon *AbstractMonitor) Validate() []string {
 		mon.Threshold = 100
 	}
 
+	if mon.IdentifiedAfter < 0 {
+		mon.IdentifiedAfter = 0
+	}
+
+	if mon.WatchingPeriod < 0 {
+		mon.WatchingPeriod = 0
+	}
+
+	if mon.UpdateEvery < 0 {
+		mon.UpdateEvery = 0
+	}
+
+	if mon.FailReasonsSize <= 0 {
+		mon.FailReasonsSize = DefaultFailReasonsSize
+	}
+
+	if mon.RecoveryCount < 0 {
+		mon.RecoveryCount = 0
+	}
+
+	if mon.RecoveryCount > mon.HistorySize {
+		mon.RecoveryCount = mon.HistorySize
+	}
+
+	if mon.RecoveryThreshold < 0 || mon.RecoveryThreshold > 100 {
+		errs = append(errs, "'recovery_threshold' must be a percentage between 0 and 100")
+	}
+
+	if mon.FlapThresholdHigh <= 0 {
+		mon.FlapThresholdHigh = DefaultFlapThresholdHigh
+	}
+
+	if mon.FlapThresholdLow <= 0 {
+		mon.FlapThresholdLow = DefaultFlapThresholdLow
+	}
+
+	if mon.FlapThresholdLow >= mon.FlapThresholdHigh {
+		errs = append(errs, "'flap_threshold_low' must be lower than 'flap_threshold_high'")
+	}
+
+	if mon.PerformanceWindow <= 0 {
+		mon.PerformanceWindow = mon.HistorySize
+	}
+
+	if mon.PerformanceThreshold < 0 {
+		mon.PerformanceThreshold = 0
+	}
+
+	if mon.PerformanceThresholdMs < 0 {
+		mon.PerformanceThresholdMs = 0
+	}
+
+	mon.PerformanceStat = strings.ToLower(mon.PerformanceStat)
+	switch mon.PerformanceStat {
+		case "median", "p95":
+			break
+		case "":
+			mon.PerformanceStat = DefaultPerformanceStat
+		default:
+			errs = append(errs, "Unsupported performance statistic: "+mon.PerformanceStat)
+	}
+
 	if err := mon.Template.Fixed.Compile(); err != nil {
 		errs = append(errs, "Could not compile \"fixed\" template: "+err.Error())
 	}
 	if err := mon.Template.Investigating.Compile(); err != nil {
 		errs = append(errs, "Could not compile \"investigating\" template: "+err.Error())
 	}
+	mon.Template.Identified.SetDefault(defaultIdentifiedTpl)
+	if err := mon.Template.Identified.Compile(); err != nil {
+		errs = append(errs, "Could not compile \"identified\" template: "+err.Error())
+	}
+	mon.Template.Watching.SetDefault(defaultWatchingTpl)
+	if err := mon.Template.Watching.Compile(); err != nil {
+		errs = append(errs, "Could not compile \"watching\" template: "+err.Error())
+	}
+	mon.Template.Update.SetDefault(defaultUpdateTpl)
+	if err := mon.Template.Update.Compile(); err != nil {
+		errs = append(errs, "Could not compile \"update\" template: "+err.Error())
+	}
+	mon.Template.Flapping.SetDefault(defaultFlappingTpl)
+	if err := mon.Template.Flapping.Compile(); err != nil {
+		errs = append(errs, "Could not compile \"flapping\" template: "+err.Error())
+	}
+	mon.Template.Dependency.SetDefault(defaultDependencyTpl)
+	if err := mon.Template.Dependency.Compile(); err != nil {
+		errs = append(errs, "Could not compile \"dependency\" template: "+err.Error())
+	}
+
+	for i := range mon.Maintenance {
+		schedule, err := parseCron(mon.Maintenance[i].Cron)
+		if err != nil {
+			errs = append(errs, "Invalid maintenance window: "+err.Error())
+			continue
+		}
+		mon.Maintenance[i].schedule = schedule
+		if mon.Maintenance[i].Duration <= 0 {
+			errs = append(errs, "Maintenance window '"+mon.Maintenance[i].Cron+"' has no duration")
+		}
+	}
+	if mon.MaintenanceStatus < 0 || mon.MaintenanceStatus > 4 {
+		errs = append(errs, "'maintenance_status' must be a component status (1-4)")
+	}
 
 	return errs
 }
@@ -162,6 +403,9 @@ func (mon *AbstractMonitor) Describe() []string {
 	if len(mon.Name) > 0 {
 		features = append(features, "Name: "+mon.Name)
 	}
+	if len(mon.Component.Name) > 0 {
+		features = append(features, "Component: "+mon.Component.Name+" (ID: "+strconv.Itoa(mon.ComponentID)+")")
+	}
 	if len(mon.Target) > 0 {
 		features = append(features, "Target: "+mon.Target)
 	} else {
@@ -173,6 +417,36 @@ func (mon *AbstractMonitor) Describe() []string {
 	if mon.Resync > 0 {
 		features = append(features, "Resyncs cycle: " + strconv.Itoa(mon.Resync))
 	}
+	if mon.IdentifiedAfter > 0 {
+		features = append(features, "Identified after: "+(mon.IdentifiedAfter*time.Second).String())
+	}
+	if mon.WatchingPeriod > 0 {
+		features = append(features, "Watching period: "+(mon.WatchingPeriod*time.Second).String())
+	}
+	if mon.UpdateEvery > 0 {
+		features = append(features, "Incident updates every: "+(mon.UpdateEvery*time.Second).String())
+	}
+	if mon.RecoveryCount > 0 {
+		features = append(features, "Recovery count: "+strconv.Itoa(mon.RecoveryCount))
+	}
+	if mon.RecoveryThreshold > 0 {
+		features = append(features, "Recovery threshold: "+strconv.Itoa(mon.RecoveryThreshold)+"%")
+	}
+	if mon.FlapDetection {
+		features = append(features, "Flap detection: "+strconv.Itoa(mon.FlapThresholdLow)+"% - "+strconv.Itoa(mon.FlapThresholdHigh)+"%")
+	}
+	if mon.performanceEnabled() {
+		features = append(features, "Performance threshold: "+strconv.Itoa(mon.PerformanceThreshold)+"% / "+strconv.Itoa(mon.PerformanceThresholdMs)+"ms ("+mon.PerformanceStat+" over "+strconv.Itoa(mon.PerformanceWindow)+" checks)")
+	}
+	if len(mon.DependsOn) > 0 {
+		features = append(features, "Depends on: "+strings.Join(mon.DependsOn, ", "))
+	}
+	if len(mon.Maintenance) > 0 {
+		features = append(features, "Maintenance windows: "+strconv.Itoa(len(mon.Maintenance)))
+	}
+	if mon.MaintenanceFromCachet {
+		features = append(features, "Honours Cachet scheduled maintenance")
+	}
 	if len(mon.ShellHookOnSuccess) > 0 {
 		features = append(features, "Has a 'on_success' shellhook")
 	}
@@ -183,8 +457,12 @@ func (mon *AbstractMonitor) Describe() []string {
 	return features
 }
 
-func (mon *AbstractMonitor) ReloadCachetData() {
-	compInfo := mon.config.API.GetComponentData(mon.ComponentID)
+func (mon *AbstractMonitor) ReloadCachetData() error {
+	compInfo, err := mon.config.API.GetComponentData(mon.apiContext(), mon.ComponentID)
+	if err != nil {
+		logrus.Warnf("Could not get data from component (id: %d): %v", mon.ComponentID, err)
+		return err
+	}
 
 	logrus.Infof("Current CachetHQ ID: %d", compInfo.ID)
 	logrus.Infof("Current CachetHQ name: %s", compInfo.Name)
@@ -214,36 +492,63 @@ func (mon *AbstractMonitor) ReloadCachetData() {
 	}
 
 	mon.currentStatus = compInfo.Status
-	mon.Enabled = compInfo.Enabled
+	mon.Enabled = compInfo.Enabled && !mon.paused
 
-	mon.incident,_ = compInfo.LoadCurrentIncident(mon.config)
+	previousIncident := mon.incident
+	mon.incident, err = compInfo.LoadCurrentIncident(mon.apiContext(), mon.config)
+	if err != nil {
+		logrus.Warnf("Could not get current incident of component (id: %d): %v", mon.ComponentID, err)
+		mon.incident = previousIncident
+		return err
+	}
+
+	if mon.incident == nil || previousIncident == nil || previousIncident.ID != mon.incident.ID {
+		mon.incidentIsPerformance = false
+		mon.incidentStart = time.Time{}
+		mon.watchingSince = time.Time{}
+		if mon.incident != nil {
+			// the outage start is unknown, count from now on
+			mon.incidentStart = time.Now()
+			if mon.incident.IsWatching() {
+				mon.watchingSince = time.Now()
+			}
+		}
+	}
 
 	if mon.incident != nil {
 		logrus.Infof("Current incident ID: %v", mon.incident.ID)
 	} else {
 		logrus.Infof("No current incident")
 	}
+
+	return nil
 }
 
 func (mon *AbstractMonitor) Init(cfg *CachetMonitor) bool {
 	mon.config = cfg
+	cfg.registerMonitor(mon)
 
 	IsValid := true
 
-	mon.ReloadCachetData()
+	if err := mon.ReloadCachetData(); err != nil {
+		IsValid = false
+	}
 
 	if mon.ComponentID == 0 {
 		logrus.Infof("ComponentID couldn't be retreived")
 		IsValid = false
 	}
 
-	mon.history = append(mon.history, mon.isUp())
+	// seed the history with the component status unless the previous state could be restored
+	if !mon.loadState() {
+		mon.pushHistory(mon.isUp(), mon.isPartial())
+	}
 
 	return IsValid
 }
 
 func (mon *AbstractMonitor) triggerShellHook(l *logrus.Entry, hooktype string, hook string, data string) {
-	if len(hook) == 0 {
+	if len(hook) == 0 || mon.checkOnly {
 		return
 	}
 	l.Infof("Sending '%s' shellhook", hooktype)
@@ -253,24 +558,43 @@ func (mon *AbstractMonitor) triggerShellHook(l *logrus.Entry, hooktype string, h
 	if err != nil {
 	    l.Warnf("Error when processing shellhook '%s': %s", hooktype, err)
 	    l.Warnf("Command output: %s", out)
+	    promShellHookFailures.add(1, mon.promLabelValues(hooktype)...)
 	}
 }
 
+// ClockStart ticks the monitor in the background until ClockStop is called.
+// The channels are created before returning, so the monitor can be stopped
+// or sent admin commands from the calling goroutine right away.
 func (mon *AbstractMonitor) ClockStart(cfg *CachetMonitor, iface MonitorInterface, wg *sync.WaitGroup) {
 	wg.Add(1)
 
 	mon.stopC = make(chan bool)
+	mon.doneC = make(chan bool)
+	mon.commandC = make(chan func())
+	mon.ctx, mon.cancel = context.WithCancel(context.Background())
+
+	go mon.clockLoop(cfg, iface, wg)
+}
+
+func (mon *AbstractMonitor) clockLoop(cfg *CachetMonitor, iface MonitorInterface, wg *sync.WaitGroup) {
+	defer close(mon.doneC)
 
 	if cfg.Immediate {
 		mon.tick(iface)
 	}
+	mon.publishStatus(iface)
 
-	ticker := time.NewTicker(mon.Interval * time.Second)
+	ticker := time.NewTicker(iface.checkInterval())
 	for {
 		select {
 		case <-ticker.C:
 			mon.tick(iface)
+			mon.publishStatus(iface)
+		case fn := <-mon.commandC:
+			// admin API actions, see AbstractMonitor.run
+			fn()
 		case <-mon.stopC:
+			mon.saveState(true)
 			wg.Done()
 			return
 		}
@@ -278,18 +602,101 @@ func (mon *AbstractMonitor) ClockStart(cfg *CachetMonitor, iface MonitorInterfac
 }
 
 func (mon *AbstractMonitor) ClockStop() {
+	if mon.stopC == nil {
+		// never started
+		return
+	}
+
 	select {
 	case <-mon.stopC:
 		return
 	default:
 		close(mon.stopC)
+		mon.cancel()
+		if mon.config != nil {
+			mon.config.unregisterMonitor(mon)
+		}
 	}
 }
 
+// clockWait blocks until a stopped monitor has finished its last tick
+func (mon *AbstractMonitor) clockWait() {
+	if mon.doneC != nil {
+		<-mon.doneC
+	}
+}
+
+// apiContext returns the context used for Cachet API calls
+func (mon *AbstractMonitor) apiContext() context.Context {
+	if mon.ctx == nil {
+		return context.Background()
+	}
+
+	return mon.ctx
+}
+
+// setComponentStatus updates the component status in Cachet, queueing it while Cachet is unreachable
+func (mon *AbstractMonitor) setComponentStatus(l *logrus.Entry, status int) {
+	_, err := mon.config.API.SetComponentStatus(mon.apiContext(), mon, status)
+	if err == nil {
+		mon.config.Queue.Delivered(mon.ComponentID)
+		return
+	}
+
+	if isTransientError(err) && mon.config.Queue.Push(QueueEntry{
+		Type:      QueueEntryComponentStatus,
+		ID:        mon.ComponentID,
+		Status:    status,
+		Timestamp: time.Now().Unix(),
+	}) {
+		l.Warnf("Could not set status %d on component %d, queued: %v", status, mon.ComponentID, err)
+		mon.currentStatus = status
+		return
+	}
+
+	l.Warnf("Could not set status %d on component %d: %v", status, mon.ComponentID, err)
+}
+
+// sendMetrics sends a metric point in the background, queueing it while Cachet is unreachable
+func (mon *AbstractMonitor) sendMetrics(l *logrus.Entry, metricname string, ids []int, value float64) {
+	if len(ids) == 0 {
+		return
+	}
+
+	timestamp := time.Now().Unix()
+	go func() {
+		for _, id := range ids {
+			l.Infof("Sending %s metric ID:%d => %v", metricname, id, value)
+
+			err := mon.config.API.SendMetricPoint(mon.apiContext(), id, value, timestamp)
+			if err == nil {
+				l.Debugf("Sent %s metric ID:%d => %v", metricname, id, value)
+				continue
+			}
+
+			if isTransientError(err) && mon.config.Queue.Push(QueueEntry{
+				Type:      QueueEntryMetric,
+				ID:        id,
+				Value:     value,
+				Timestamp: timestamp,
+			}) {
+				l.Warnf("Sending %s metric ID:%d => %v failed, queued: %v", metricname, id, value, err)
+				continue
+			}
+
+			l.Warnf("Sending %s metric ID:%d => %v: %v", metricname, id, value, err)
+		}
+	}()
+}
+
 func (mon *AbstractMonitor) isUp() bool {
 	return (mon.currentStatus == 1)
 }
 
+func (mon *AbstractMonitor) isDegraded() bool {
+	return (mon.currentStatus == 2)
+}
+
 func (mon *AbstractMonitor) isPartial() bool {
 	return (mon.currentStatus == 3)
 }
@@ -300,6 +707,41 @@ func (mon *AbstractMonitor) isCritical() bool {
 
 func (mon *AbstractMonitor) test(l *logrus.Entry) bool { return false }
 
+// checkInterval is the time between two ticks
+func (mon *AbstractMonitor) checkInterval() time.Duration {
+	return mon.Interval * time.Second
+}
+
+// pushHistory appends a check result, keeping at most HistorySize entries
+func (mon *AbstractMonitor) pushHistory(isUp bool, isWarning bool) {
+	if len(mon.warningHistory) != len(mon.history) {
+		mon.warningHistory = make([]bool, len(mon.history))
+	}
+
+	if len(mon.history) >= mon.HistorySize {
+		mon.history = mon.history[len(mon.history)-(mon.HistorySize-1):]
+		mon.warningHistory = mon.warningHistory[len(mon.warningHistory)-(mon.HistorySize-1):]
+	}
+	mon.history = append(mon.history, isUp)
+	mon.warningHistory = append(mon.warningHistory, isWarning)
+}
+
+// setDetail records something the test observed, for the check command
+func (mon *AbstractMonitor) setDetail(name string, value interface{}) {
+	if mon.details == nil {
+		mon.details = map[string]interface{}{}
+	}
+	mon.details[name] = value
+}
+
+// addMetricPoint queues a metric point to be sent once the check is over
+func (mon *AbstractMonitor) addMetricPoint(name string, ids []int, value float64) {
+	if len(ids) == 0 {
+		return
+	}
+	mon.metricPoints = append(mon.metricPoints, metricPoint{name: name, ids: ids, value: value})
+}
+
 func (mon *AbstractMonitor) tick(iface MonitorInterface) {
 	l := logrus.WithFields(logrus.Fields{ "monitor": mon.Name })
 
@@ -308,21 +750,31 @@ func (mon *AbstractMonitor) tick(iface MonitorInterface) {
 		return
 	}
 
+	mon.lastTick = time.Now()
 	reqStart := getMs()
 	isUp := true
+	mon.customLag = -1
+	mon.noLag = false
+	mon.warning = false
+	mon.metricPoints = nil
+	mon.details = nil
 	isUp = iface.test(l)
 	lag := getMs() - reqStart
+	result := "success"
+	if !isUp {
+		result = "failure"
+	}
+	promChecks.add(1, mon.promLabelValues(result)...)
+	promCheckDuration.observe(float64(lag)/1000, mon.promLabelValues()...)
+	if mon.customLag >= 0 {
+		lag = mon.customLag
+	}
 
 	if len(mon.history) == mon.HistorySize-1 {
 		l.Debugf("monitor %v is now fully operational", mon.Name)
 	}
 
-	if len(mon.history) >= mon.HistorySize {
-		mon.history = mon.history[len(mon.history)-(mon.HistorySize-1):]
-	}
-	mon.history = append(mon.history, isUp)
-
-	mon.AnalyseData(l)
+	isUp = mon.recordCheck(l, isUp, lag)
 
 	// Will trigger shellhook 'on_failure' as this isn't done in implementations
 	if ! isUp {
@@ -330,10 +782,15 @@ func (mon *AbstractMonitor) tick(iface MonitorInterface) {
 	}
 
 	// report lag
-	if mon.MetricID > 0 {
-		go mon.config.API.SendMetric(l, mon.MetricID, lag)
+	if !mon.noLag {
+		if mon.MetricID > 0 {
+			mon.sendMetrics(l, "lag", []int{mon.MetricID}, float64(lag))
+		}
+		mon.sendMetrics(l, "response time", mon.Metrics.ResponseTime, float64(lag))
+	}
+	for _, point := range mon.metricPoints {
+		mon.sendMetrics(l, point.name, point.ids, point.value)
 	}
-	go mon.config.API.SendMetrics(l, "response time", mon.Metrics.ResponseTime, lag)
 
 	if(mon.Resync > 0) {
 		mon.resyncMod = (mon.resyncMod+1) % mon.Resync
@@ -344,28 +801,129 @@ func (mon *AbstractMonitor) tick(iface MonitorInterface) {
 			l.Debugf("Resync progressbar: %d/%d", mon.resyncMod, mon.Resync)
 		}
 	}
+
+	mon.saveState(false)
 }
 
-// TODO: test
-// AnalyseData decides if the monitor is statistically up or down and creates / resolves an incident
-func (mon *AbstractMonitor) AnalyseData(l *logrus.Entry) {
-	// look at the past few incidents
+// recordCheck adds the result of a test to the history and, unless another location leads or a maintenance
+// is running, updates the component status and incidents. It returns the result confirmed by the peers.
+func (mon *AbstractMonitor) recordCheck(l *logrus.Entry, isUp bool, lag int64) bool {
+	isUp = mon.applyQuorum(l, isUp)
+
+	mon.pushHistory(isUp, !isUp && mon.warning)
+	mon.lastLag = lag
+	if isUp && !mon.noLag && mon.performanceEnabled() {
+		mon.pushLag(lag)
+	}
+
+	if !isUp {
+		mon.pushFailReason(mon.lastFailReason)
+	}
+
+	if !mon.isPeerLeader() {
+		l.Debugf("Incidents and component status are left to the leader location %s", mon.peerLeader)
+	} else {
+		inMaintenance := mon.updateMaintenance(l)
+		// availability and incident count are recorded during maintenance too
+		mon.sendAnalysisMetrics(l)
+
+		if inMaintenance {
+			l.Debugf("In maintenance: incidents and component status are left unchanged")
+		} else {
+			mon.AnalyseData(l)
+			mon.AnalysePerformance(l)
+			mon.postPeriodicUpdate(l)
+		}
+	}
+
+	return isUp
+}
+
+// countDown returns the number of failed checks in history, and how many of them were warnings
+func (mon *AbstractMonitor) countDown() (int, int) {
 	numDown := 0
-	mon.currentUpCount = 0
-	mon.currentDownCount = 0
-	for _, wasUp := range mon.history {
-		if wasUp == false {
+	numWarning := 0
+	for i, wasUp := range mon.history {
+		if !wasUp {
 			numDown++
-			mon.currentDownCount++
+			if mon.warningHistory[i] {
+				numWarning++
+			}
+		}
+	}
+
+	return numDown, numWarning
+}
+
+// thresholdsTriggered tells whether the failed checks of a saturated history reach
+// the outage, critical outage or partial outage thresholds
+func (mon *AbstractMonitor) thresholdsTriggered(numDown int, numWarning int) (bool, bool, bool) {
+	triggered := false
+	criticalTriggered := false
+	partialTriggered := false
+
+	if numDown == 0 {
+		return false, false, false
+	}
+
+	t := (float32(numDown) / float32(len(mon.history))) * 100
+	if mon.ThresholdCount > 0 || mon.Threshold > 0 {
+		if mon.ThresholdCount > 0 {
+			triggered = (numDown >= mon.ThresholdCount)
 		} else {
-			mon.currentUpCount++
+			triggered = (int(t) > mon.Threshold)
+		}
+	} else {
+		if mon.CriticalThresholdCount > 0 || mon.CriticalThreshold > 0 {
+			if mon.CriticalThresholdCount > 0 {
+				criticalTriggered = (numDown >= mon.CriticalThresholdCount)
+			} else {
+				criticalTriggered = (int(t) > mon.CriticalThreshold)
+			}
+		}
+		if ! criticalTriggered {
+			if mon.PartialThresholdCount > 0 || mon.PartialThreshold > 0 {
+				partialTriggered = (mon.PartialThresholdCount > 0 && numDown >= mon.PartialThresholdCount) || (mon.PartialThreshold > 0 && int(t) > mon.PartialThreshold)
+			}
 		}
 	}
+	if (triggered || criticalTriggered) && numWarning == numDown {
+		// every failure was a warning: downgrade to partial outage
+		triggered = false
+		criticalTriggered = false
+		partialTriggered = true
+	}
+
+	return triggered, criticalTriggered, partialTriggered
+}
+
+// sendAnalysisMetrics posts the availability and incident count points of the history.
+// Unlike AnalyseData, it also runs during maintenance windows.
+func (mon *AbstractMonitor) sendAnalysisMetrics(l *logrus.Entry) {
+	numDown, numWarning := mon.countDown()
+	if numDown == 0 {
+		mon.sendMetrics(l, "availability", mon.Metrics.Availability, 1)
+		return
+	}
+
+	if len(mon.history) != mon.HistorySize {
+		return
+	}
+	if triggered, criticalTriggered, partialTriggered := mon.thresholdsTriggered(numDown, numWarning); triggered || criticalTriggered || partialTriggered {
+		mon.sendMetrics(l, "incident count", mon.Metrics.IncidentCount, 1)
+	}
+}
+
+// AnalyseData decides if the monitor is statistically up or down and creates / resolves an incident
+func (mon *AbstractMonitor) AnalyseData(l *logrus.Entry) {
+	// look at the past few incidents
+	numDown, numWarning := mon.countDown()
+	mon.currentDownCount = numDown
+	mon.currentUpCount = len(mon.history) - numDown
 
 	t := (float32(numDown) / float32(len(mon.history))) * 100
 	if numDown == 0 {
 		l.Printf("monitor is fully up")
-		go mon.config.API.SendMetrics(l, "availability", mon.Metrics.Availability, 1)
 	}
 
 	if len(mon.history) != mon.HistorySize {
@@ -374,32 +932,16 @@ func (mon *AbstractMonitor) AnalyseData(l *logrus.Entry) {
 		return
 	}
 
-	triggered := false
-	criticalTriggered := false
-	partialTriggered := false
+	mon.detectFlapping(l)
+
+	triggered, criticalTriggered, partialTriggered := mon.thresholdsTriggered(numDown, numWarning)
 
 	if numDown > 0 {
-		if mon.ThresholdCount > 0 || mon.Threshold > 0 {
-			if mon.ThresholdCount > 0 {
-				triggered = (numDown >= mon.ThresholdCount)
-				l.Printf("monitor down (down count=%d, threshold=%d)", numDown, mon.Threshold)
-			} else {
-				triggered = (int(t) > mon.Threshold)
-				l.Printf("monitor down (down percentage=%.2f%%, threshold=%d%%)", t, mon.Threshold)
-			}
+		if mon.ThresholdCount > 0 {
+			l.Printf("monitor down (down count=%d, threshold=%d)", numDown, mon.Threshold)
+		} else if mon.Threshold > 0 {
+			l.Printf("monitor down (down percentage=%.2f%%, threshold=%d%%)", t, mon.Threshold)
 		} else {
-			if mon.CriticalThresholdCount > 0 || mon.CriticalThreshold > 0 {
-				if mon.CriticalThresholdCount > 0 {
-					criticalTriggered = (numDown >= mon.CriticalThresholdCount)
-				} else {
-					criticalTriggered = (int(t) > mon.CriticalThreshold)
-				}
-			}
-			if ! criticalTriggered {
-				if mon.PartialThresholdCount > 0 || mon.PartialThreshold > 0 {
-					partialTriggered = (mon.PartialThresholdCount > 0 && numDown >= mon.PartialThresholdCount) || (mon.PartialThreshold > 0 && int(t) > mon.PartialThreshold)
-				}
-			}
 			if mon.CriticalThresholdCount > 0 || mon.PartialThresholdCount > 0 {
 				l.Printf("monitor down (down count=%d, partial threshold=%d, critical threshold=%d)", numDown, mon.PartialThresholdCount, mon.CriticalThresholdCount)
 			}
@@ -407,6 +949,10 @@ func (mon *AbstractMonitor) AnalyseData(l *logrus.Entry) {
 				l.Printf("monitor down (down percentage=%.2f%%, partial threshold=%d%%, critical threshold=%d%%)", t, mon.PartialThreshold, mon.CriticalThreshold)
 			}
 		}
+		if partialTriggered && numWarning == numDown {
+			l.Printf("monitor only failed with warnings (warning count=%d)", numWarning)
+		}
+
 		l.Debugf("Down count: %d, history: %d, percentage: %.2f%%", numDown, len(mon.history), t)
 		l.Debugf("Is triggered: %t", triggered)
 		l.Debugf("Is critically Triggered: %t", criticalTriggered)
@@ -414,10 +960,9 @@ func (mon *AbstractMonitor) AnalyseData(l *logrus.Entry) {
 		l.Debugf("Monitor's current incident: %v", mon.incident)
 
 		if triggered || criticalTriggered || partialTriggered {
-			// Process metric
-			go mon.config.API.SendMetrics(l, "incident count", mon.Metrics.IncidentCount, 1)
-
-			if mon.incident == nil {
+			if mon.incident == nil && mon.suppressedByParent(l) {
+				// the parent incident covers this outage, only reflect the status
+			} else if mon.incident == nil {
 				// create incident
 				mon.currentStatus = 2
 				tplData := getTemplateData(mon)
@@ -438,21 +983,52 @@ func (mon *AbstractMonitor) AnalyseData(l *logrus.Entry) {
 
 				// is down, create an incident
 				l.Warnf("creating incident. Monitor is down: %v", mon.lastFailReason)
+				mon.incidentStart = mon.now()
 				// set investigating status
 				mon.incident.SetInvestigating()
 				// create incident 
-				if err := mon.incident.Send(mon.config); err != nil {
+				if err := mon.incident.Send(mon.apiContext(), mon.config); err != nil {
 					l.Printf("Error sending incident: %v", err)
+					// retry on next tick
+					mon.incident = nil
+				} else {
+					promIncidentsOpened.add(1, mon.promLabelValues()...)
+				}
+			} else if mon.incidentIsPerformance {
+				// escalate the performance incident to an outage
+				tplData := getTemplateData(mon)
+				tplData["FailReason"] = mon.lastFailReason
+
+				mon.incident.Name, mon.incident.Message = mon.Template.Investigating.Exec(tplData)
+				mon.incident.ComponentStatus = 4
+				if partialTriggered {
+					mon.incident.ComponentStatus = 3
 				}
+				mon.incidentIsPerformance = false
+
+				l.Warnf("escalating performance incident. Monitor is down: %v", mon.lastFailReason)
+				if err := mon.incident.Send(mon.apiContext(), mon.config); err != nil {
+					l.Printf("Error sending incident: %v", err)
+				}
+			} else if mon.incident.IsWatching() {
+				// relapse: back to identified on the same incident
+				l.Warnf("monitor is down again while watching incident %d: %v", mon.incident.ID, mon.lastFailReason)
+				mon.watchingSince = time.Time{}
+				mon.incident.SetIdentified()
+				mon.sendIncidentUpdate(l, &mon.Template.Identified)
+			} else if mon.incident.IsInvestigating() && mon.IdentifiedAfter > 0 && mon.now().Sub(mon.incidentStart) >= mon.IdentifiedAfter*time.Second {
+				l.Warnf("outage lasting for %v, moving incident %d to identified", mon.now().Sub(mon.incidentStart), mon.incident.ID)
+				mon.incident.SetIdentified()
+				mon.sendIncidentUpdate(l, &mon.Template.Identified)
 			}
 			if triggered || criticalTriggered {
 				if (! mon.isCritical()) {
-					mon.config.API.SetComponentStatus(mon, 4)
+					mon.setComponentStatus(l, 4)
 				}
 			}
 			if partialTriggered {
 				if (! mon.isPartial()) {
-					mon.config.API.SetComponentStatus(mon, 3)
+					mon.setComponentStatus(l, 3)
 				}
 			}
 			return
@@ -462,34 +1038,214 @@ func (mon *AbstractMonitor) AnalyseData(l *logrus.Entry) {
 	// we are up to normal
 
 	// global status seems incorrect though we couldn't fid any prior incident
-	if ! mon.isUp() && mon.incident == nil {
+	if ! mon.isUp() && mon.incident == nil && ! mon.performanceDegraded {
 		l.Info("Reseting component's status")
 		mon.lastFailReason = ""
 		mon.incident = nil
-		mon.config.API.SetComponentStatus(mon, 1)
+		mon.setComponentStatus(l, 1)
 		return
 	}
 
-	if mon.incident == nil {
+	// performance incidents are resolved by AnalysePerformance
+	if mon.incident == nil || mon.incidentIsPerformance {
 		return
 	}
 
+	if mon.flapping {
+		l.Infof("monitor is flapping (state change=%.2f%%), holding incident %d", mon.stateChange, mon.incident.ID)
+		return
+	}
+
+	if consecutiveUp := mon.consecutiveUpCount(); consecutiveUp < mon.RecoveryCount {
+		l.Infof("holding incident %d until recovery (consecutive successes=%d, recovery count=%d)", mon.incident.ID, consecutiveUp, mon.RecoveryCount)
+		return
+	}
+
+	if mon.RecoveryThreshold > 0 && int(t) > mon.RecoveryThreshold {
+		l.Infof("holding incident %d until recovery (down percentage=%.2f%%, recovery threshold=%d%%)", mon.incident.ID, t, mon.RecoveryThreshold)
+		return
+	}
+
+	if mon.WatchingPeriod > 0 {
+		if !mon.incident.IsWatching() {
+			l.Infof("Watching incident %d", mon.incident.ID)
+			mon.watchingSince = mon.now()
+			mon.incident.SetWatching()
+			mon.sendIncidentUpdate(l, &mon.Template.Watching)
+			mon.currentStatus = 1
+			return
+		}
+
+		if mon.now().Sub(mon.watchingSince) < mon.WatchingPeriod*time.Second {
+			l.Debugf("Watching incident %d since %v", mon.incident.ID, mon.now().Sub(mon.watchingSince))
+			return
+		}
+	}
+
 	// was down, created an incident, its now ok, make it resolved.
 	l.Infof("Resolving incident %d", mon.incident.ID)
 
 	// resolve incident
 	tplData := getTemplateData(mon)
 	tplData["incident"] = mon.incident
+	tplData["Duration"] = mon.outageDuration()
 
 	subject, message := mon.Template.Fixed.Exec(tplData)
 	mon.incident.Name = subject
 	mon.incident.Message = message
 	mon.incident.SetFixed()
-	if err := mon.incident.Send(mon.config); err != nil {
+	if err := mon.incident.Send(mon.apiContext(), mon.config); err != nil {
 		l.Warnf("Error updating sending incident: %v", err)
+	} else {
+		promIncidentsResolved.add(1, mon.promLabelValues()...)
 	}
 
 	mon.lastFailReason = ""
 	mon.incident = nil
+	mon.incidentStart = time.Time{}
+	mon.watchingSince = time.Time{}
 	mon.currentStatus = 1
 }
+
+// now returns the current time, or the simulated one
+func (mon *AbstractMonitor) now() time.Time {
+	if mon.config == nil {
+		return time.Now()
+	}
+
+	return mon.config.now()
+}
+
+// outageDuration returns for how long the current incident has been open
+func (mon *AbstractMonitor) outageDuration() time.Duration {
+	if mon.incidentStart.IsZero() {
+		return 0
+	}
+
+	return mon.now().Sub(mon.incidentStart) / time.Second * time.Second
+}
+
+// pushFailReason keeps the last FailReasonsSize fail reasons (prefixed with their date)
+func (mon *AbstractMonitor) pushFailReason(reason string) {
+	if len(mon.failReasons) >= mon.FailReasonsSize {
+		mon.failReasons = mon.failReasons[len(mon.failReasons)-(mon.FailReasonsSize-1):]
+	}
+	mon.failReasons = append(mon.failReasons, "["+mon.now().Format(mon.config.DateFormat)+"] "+reason)
+}
+
+// postPeriodicUpdate posts an incident update every UpdateEvery seconds while an outage is ongoing
+func (mon *AbstractMonitor) postPeriodicUpdate(l *logrus.Entry) {
+	if mon.UpdateEvery <= 0 || mon.incident == nil || mon.incident.ID == 0 || mon.incident.IsWatching() {
+		mon.lastIncidentUpdate = time.Time{}
+		return
+	}
+
+	if mon.lastIncidentUpdate.IsZero() {
+		mon.lastIncidentUpdate = mon.now()
+		return
+	}
+
+	if mon.now().Sub(mon.lastIncidentUpdate) < mon.UpdateEvery*time.Second {
+		return
+	}
+
+	tplData := getTemplateData(mon)
+	tplData["FailReason"] = mon.lastFailReason
+	tplData["FailReasons"] = mon.failReasons
+	tplData["DownCount"] = mon.currentDownCount
+	tplData["incident"] = mon.incident
+	tplData["Duration"] = mon.outageDuration()
+
+	_, message := mon.Template.Update.Exec(tplData)
+
+	l.Infof("Posting update on incident %d", mon.incident.ID)
+	if err := mon.incident.PostUpdate(mon.apiContext(), mon.config, message); err != nil {
+		l.Warnf("Error posting incident update: %v", err)
+	}
+	mon.lastIncidentUpdate = mon.now()
+}
+
+// sendIncidentUpdate renders tpl into the open incident and sends it
+func (mon *AbstractMonitor) sendIncidentUpdate(l *logrus.Entry, tpl *MessageTemplate) {
+	tplData := getTemplateData(mon)
+	tplData["FailReason"] = mon.lastFailReason
+	tplData["incident"] = mon.incident
+	tplData["Duration"] = mon.outageDuration()
+
+	mon.incident.Name, mon.incident.Message = tpl.Exec(tplData)
+	if err := mon.incident.Send(mon.apiContext(), mon.config); err != nil {
+		l.Warnf("Error updating incident: %v", err)
+	}
+}
+
+// consecutiveUpCount returns the number of successful checks at the end of history
+func (mon *AbstractMonitor) consecutiveUpCount() int {
+	count := 0
+	for i := len(mon.history) - 1; i >= 0 && mon.history[i]; i-- {
+		count++
+	}
+
+	return count
+}
+
+// stateChangePercent returns the weighted % of state changes in history (recent changes weigh more)
+func (mon *AbstractMonitor) stateChangePercent() float32 {
+	n := len(mon.history)
+	if n < 2 {
+		return 0
+	}
+
+	changes := float32(0)
+	for i := 1; i < n; i++ {
+		if mon.history[i] == mon.history[i-1] {
+			continue
+		}
+
+		// weights go linearly from 0.8 (oldest change) to 1.2 (latest change)
+		weight := float32(1)
+		if n > 2 {
+			weight = 0.8 + 0.4*float32(i-1)/float32(n-2)
+		}
+		changes += weight
+	}
+
+	return changes / float32(n-1) * 100
+}
+
+// detectFlapping updates the flapping state and posts an update on the open incident when flapping starts
+func (mon *AbstractMonitor) detectFlapping(l *logrus.Entry) {
+	if !mon.FlapDetection {
+		return
+	}
+
+	mon.stateChange = mon.stateChangePercent()
+
+	if mon.flapping {
+		if int(mon.stateChange) < mon.FlapThresholdLow {
+			l.Infof("monitor stopped flapping (state change=%.2f%%, low threshold=%d%%)", mon.stateChange, mon.FlapThresholdLow)
+			mon.flapping = false
+		}
+		return
+	}
+
+	if int(mon.stateChange) <= mon.FlapThresholdHigh {
+		return
+	}
+
+	l.Warnf("monitor started flapping (state change=%.2f%%, high threshold=%d%%)", mon.stateChange, mon.FlapThresholdHigh)
+	mon.flapping = true
+
+	if mon.incident == nil || mon.incident.ID == 0 {
+		return
+	}
+
+	tplData := getTemplateData(mon)
+	tplData["FailReason"] = mon.lastFailReason
+	tplData["StateChange"] = mon.stateChange
+	tplData["incident"] = mon.incident
+
+	_, message := mon.Template.Flapping.Exec(tplData)
+	if err := mon.incident.PostUpdate(mon.apiContext(), mon.config, message); err != nil {
+		l.Warnf("Error posting flapping update: %v", err)
+	}
+}
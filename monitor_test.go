@@ -1,7 +1,223 @@
 package cachet
 
 import (
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"cachet/cachettest"
 )
 
-func TestAnalyseData(t *testing.T) {}
+func TestAnalyseData(t *testing.T) {
+	tests := []struct {
+		name     string
+		monitor  *MockMonitor
+		checks   string
+		expected []string
+	}{
+		{
+			name:     "below threshold",
+			monitor:  &MockMonitor{AbstractMonitor: AbstractMonitor{ThresholdCount: 3, HistorySize: 4}},
+			checks:   "uudduudu",
+			expected: []string{},
+		},
+		{
+			name:     "outage then recovery",
+			monitor:  &MockMonitor{AbstractMonitor: AbstractMonitor{ThresholdCount: 2, HistorySize: 4}},
+			checks:   "uudddduuu",
+			expected: []string{"incident #1 created", "component => partial outage", "component => major outage", "incident #1 fixed", "component => operational"},
+		},
+		{
+			name:     "partial threshold",
+			monitor:  &MockMonitor{AbstractMonitor: AbstractMonitor{PartialThresholdCount: 2, CriticalThresholdCount: 4, HistorySize: 4}},
+			checks:   "uuudduuuu",
+			expected: []string{"incident #1 created", "component => partial outage", "incident #1 fixed", "component => operational"},
+		},
+		{
+			name:     "warnings only",
+			monitor:  &MockMonitor{AbstractMonitor: AbstractMonitor{ThresholdCount: 2, HistorySize: 3}},
+			checks:   "uwwwuuu",
+			expected: []string{"incident #1 created", "component => partial outage", "incident #1 fixed", "component => operational"},
+		},
+		{
+			name:     "watching period",
+			monitor:  &MockMonitor{AbstractMonitor: AbstractMonitor{ThresholdCount: 2, HistorySize: 3, WatchingPeriod: 120}},
+			checks:   "udduuuuuu",
+			expected: []string{"incident #1 created", "component => partial outage", "component => major outage", "incident #1 watching", "component => operational", "incident #1 fixed"},
+		},
+		{
+			name:     "identified after",
+			monitor:  &MockMonitor{AbstractMonitor: AbstractMonitor{ThresholdCount: 2, HistorySize: 3, IdentifiedAfter: 120}},
+			checks:   "udddduuu",
+			expected: []string{"incident #1 created", "component => partial outage", "component => major outage", "incident #1 identified", "incident #1 fixed", "component => operational"},
+		},
+		{
+			name:     "relapse while watching",
+			monitor:  &MockMonitor{AbstractMonitor: AbstractMonitor{ThresholdCount: 2, HistorySize: 3, WatchingPeriod: 240}},
+			checks:   "udduudduuuuuuuu",
+			expected: []string{"incident #1 created", "component => partial outage", "component => major outage", "incident #1 watching", "component => operational", "incident #1 identified", "component => partial outage", "component => major outage", "incident #1 watching", "component => operational", "incident #1 fixed"},
+		},
+		{
+			name:     "recovery count not reached",
+			monitor:  &MockMonitor{AbstractMonitor: AbstractMonitor{ThresholdCount: 2, HistorySize: 4, RecoveryCount: 3}},
+			checks:   "uddduu",
+			expected: []string{"incident #1 created", "component => partial outage", "component => major outage"},
+		},
+		{
+			name:     "recovery count",
+			monitor:  &MockMonitor{AbstractMonitor: AbstractMonitor{ThresholdCount: 2, HistorySize: 4, RecoveryCount: 3}},
+			checks:   "uddduuu",
+			expected: []string{"incident #1 created", "component => partial outage", "component => major outage", "incident #1 fixed", "component => operational"},
+		},
+	}
+
+	for _, test := range tests {
+		mon := test.monitor
+		mon.Name = "web"
+		mon.ComponentID = 1
+		cfg := &CachetMonitor{Monitors: []MonitorInterface{mon}}
+
+		simulation, err := cfg.Simulate("", simulatedChecks(test.checks))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		events := []string{}
+		for _, event := range simulation.Events {
+			events = append(events, strings.SplitN(event.String(), ":", 2)[0])
+		}
+		if strings.Join(events, ", ") != strings.Join(test.expected, ", ") {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, events)
+		}
+	}
+}
+
+func TestPeriodicUpdates(t *testing.T) {
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", ComponentID: 1, ThresholdCount: 2, HistorySize: 3, UpdateEvery: 120}}
+	cfg := &CachetMonitor{Monitors: []MonitorInterface{mon}}
+
+	// incident created on the 3rd check (2 minutes), fixed on the 10th (9 minutes): no update afterwards
+	simulation, err := cfg.Simulate("web", simulatedChecks("uddddddduuuuuu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := simulatedChecks("u")[0].Time
+	updates := []time.Duration{}
+	for _, event := range simulation.Events {
+		if event.Type == "incident_update" {
+			updates = append(updates, event.Time.Sub(start))
+		}
+	}
+
+	expected := []time.Duration{4 * time.Minute, 6 * time.Minute, 8 * time.Minute}
+	if len(updates) != len(expected) {
+		t.Fatalf("expected updates at %v, got %v", expected, updates)
+	}
+	for i := range expected {
+		if updates[i] != expected[i] {
+			t.Errorf("expected updates at %v, got %v", expected, updates)
+			break
+		}
+	}
+}
+
+// simulatedChecks returns a check per minute: u (up), d (down) or w (warning)
+func simulatedChecks(results string) []SimulatedCheck {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	checks := []SimulatedCheck{}
+	for i, result := range results {
+		checks = append(checks, SimulatedCheck{
+			Time:    start.Add(time.Duration(i) * time.Minute),
+			Up:      result == 'u',
+			Warning: result == 'w',
+		})
+	}
+
+	return checks
+}
+
+func TestStateChangePercent(t *testing.T) {
+	mon := &AbstractMonitor{history: []bool{true, true, true, true, true}}
+	if change := mon.stateChangePercent(); change != 0 {
+		t.Errorf("stable history should not change state, got %.2f%%", change)
+	}
+
+	mon.history = []bool{true, false, true, false, true}
+	if change := mon.stateChangePercent(); change != 100 {
+		t.Errorf("alternating history should change state 100%%, got %.2f%%", change)
+	}
+
+	older := &AbstractMonitor{history: []bool{false, true, true, true, true}}
+	newer := &AbstractMonitor{history: []bool{true, true, true, true, false}}
+	if older.stateChangePercent() >= newer.stateChangePercent() {
+		t.Error("recent state changes should weigh more than older ones")
+	}
+}
+
+func TestConsecutiveUpCount(t *testing.T) {
+	mon := &AbstractMonitor{history: []bool{true, false, true, true}}
+	if count := mon.consecutiveUpCount(); count != 2 {
+		t.Errorf("expected 2 consecutive successes, got %d", count)
+	}
+
+	mon.history = append(mon.history, false)
+	if count := mon.consecutiveUpCount(); count != 0 {
+		t.Errorf("expected no consecutive success, got %d", count)
+	}
+}
+
+func TestFlapDetection(t *testing.T) {
+	fake := cachettest.NewServer()
+	defer fake.Close()
+	component := fake.AddComponent(cachettest.Component{Name: "Website", Enabled: true})
+
+	cfg := &CachetMonitor{
+		API:        CachetAPI{URL: fake.URL, Token: fake.Token, Retries: -1},
+		SystemName: "test",
+		DateFormat: DefaultTimeFormat,
+	}
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", ComponentID: component.ID, ThresholdCount: 2, HistorySize: 6, FlapDetection: true}}
+	if errs := mon.Validate(); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if !mon.Init(cfg) {
+		t.Fatal("expected the monitor to load its component")
+	}
+
+	l := logrus.WithFields(logrus.Fields{"monitor": mon.Name})
+	analyse := func(results string) {
+		for _, result := range results {
+			mon.pushHistory(result == 'u', false)
+			mon.AnalyseData(l)
+		}
+	}
+
+	analyse("uuuuudd")
+	incidents := fake.Incidents()
+	if len(incidents) != 1 || len(incidents[0].Updates) != 0 {
+		t.Fatalf("expected an incident without update, got %+v", incidents)
+	}
+
+	analyse("udud")
+	if !mon.flapping {
+		t.Fatalf("expected the monitor to flap (state change=%.2f%%)", mon.stateChange)
+	}
+	incidents = fake.Incidents()
+	if len(incidents[0].Updates) != 1 || !strings.Contains(incidents[0].Updates[0].Message, "**flapping**") {
+		t.Fatalf("expected the flapping template to be posted, got %+v", incidents[0].Updates)
+	}
+
+	// below the threshold but still flapping: the incident is held
+	analyse("uuuu")
+	if incidents = fake.Incidents(); incidents[0].Status == 4 || mon.incident == nil {
+		t.Errorf("expected the incident to be held while flapping (state change=%.2f%%)", mon.stateChange)
+	}
+
+	analyse("uu")
+	if incidents = fake.Incidents(); mon.flapping || incidents[0].Status != 4 || len(incidents[0].Updates) != 1 {
+		t.Errorf("expected the incident to be fixed once stable, got %+v (flapping=%t)", incidents[0], mon.flapping)
+	}
+}
@@ -0,0 +1,376 @@
+package cachet
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const DefaultPeerPollInterval = 5
+
+// PeerGroup shares the verdicts of the monitors with cachet-monitor instances running in other locations.
+// A monitor is only considered down when a quorum of locations agrees, and a single leader
+// per monitor updates its component status and incidents.
+type PeerGroup struct {
+	// address serving the verdicts of this location to the other nodes
+	Listen string `json:"listen" yaml:"listen"`
+	// name of this location (defaults to system_name)
+	Location string `json:"location" yaml:"location"`
+	// shared by all the nodes, sent as a bearer token
+	Secret string `json:"secret" yaml:"secret"`
+	// base URLs of the other nodes (disabled when empty)
+	Nodes []string `json:"nodes" yaml:"nodes"`
+	// locations which must report a monitor down (defaults to a majority)
+	Quorum int `json:"quorum" yaml:"quorum"`
+	// seconds between two polls of the other nodes
+	PollInterval time.Duration `json:"poll_interval" yaml:"poll_interval"`
+
+	mu sync.Mutex
+	// monitor name => verdict
+	local map[string]PeerVerdict
+	// location => monitor name => verdict
+	remote map[string]map[string]PeerVerdict
+	server *http.Server
+	client *http.Client
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// PeerVerdict is the result of the last check of a monitor in a location
+type PeerVerdict struct {
+	Monitor    string `json:"monitor"`
+	Up         bool   `json:"up"`
+	FailReason string `json:"fail_reason,omitempty"`
+	// seconds since the check
+	Age float64 `json:"age"`
+
+	at time.Time
+}
+
+// peerVerdicts is served on /verdicts
+type peerVerdicts struct {
+	Location string        `json:"location"`
+	Verdicts []PeerVerdict `json:"verdicts"`
+}
+
+func (peers *PeerGroup) enabled() bool {
+	return len(peers.Nodes) > 0
+}
+
+// Validate sets the defaults and returns the configuration errors
+func (peers *PeerGroup) Validate(systemName string) []string {
+	errs := []string{}
+	if !peers.enabled() {
+		return errs
+	}
+
+	if len(peers.Location) == 0 {
+		peers.Location = systemName
+	}
+	if peers.PollInterval <= 0 {
+		peers.PollInterval = DefaultPeerPollInterval
+	}
+	if peers.Quorum == 0 {
+		peers.Quorum = (len(peers.Nodes)+1)/2 + 1
+	}
+
+	if len(peers.Secret) == 0 {
+		errs = append(errs, "'secret' has not been set")
+	}
+	if peers.Quorum < 1 || peers.Quorum > len(peers.Nodes)+1 {
+		errs = append(errs, "'quorum' must be between 1 and the number of locations ("+strconv.Itoa(len(peers.Nodes)+1)+")")
+	}
+	for _, node := range peers.Nodes {
+		if u, err := url.Parse(node); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			errs = append(errs, "Invalid node URL '"+node+"'")
+		}
+	}
+
+	return errs
+}
+
+// record stores the verdict of this location
+func (peers *PeerGroup) record(monitor string, up bool, failReason string) {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+
+	if peers.local == nil {
+		peers.local = map[string]PeerVerdict{}
+	}
+	peers.local[monitor] = PeerVerdict{Monitor: monitor, Up: up, FailReason: failReason, at: time.Now()}
+}
+
+// verdicts returns the verdicts of the locations which checked the monitor within maxAge
+func (peers *PeerGroup) verdicts(monitor string, maxAge time.Duration) map[string]PeerVerdict {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+
+	verdicts := map[string]PeerVerdict{}
+	if verdict, ok := peers.local[monitor]; ok {
+		verdicts[peers.Location] = verdict
+	}
+	for location, remote := range peers.remote {
+		if verdict, ok := remote[monitor]; ok && time.Since(verdict.at) <= maxAge {
+			verdicts[location] = verdict
+		}
+	}
+
+	return verdicts
+}
+
+// peerLeader deterministically picks the location writing to Cachet for a monitor (rendezvous hashing)
+func peerLeader(monitor string, locations []string) string {
+	leader := ""
+	var leaderHash uint32
+	for _, location := range locations {
+		h := fnv.New32a()
+		h.Write([]byte(location + "/" + monitor))
+		sum := h.Sum32()
+		if len(leader) == 0 || sum > leaderHash || (sum == leaderHash && location < leader) {
+			leader = location
+			leaderHash = sum
+		}
+	}
+
+	return leader
+}
+
+func (peers *PeerGroup) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return subtle.ConstantTimeCompare([]byte(token), []byte(peers.Secret)) == 1
+}
+
+// ServeHTTP handles GET /verdicts
+func (peers *PeerGroup) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.Trim(r.URL.Path, "/") != "verdicts" {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != "GET" {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !peers.authorized(r) {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	peers.mu.Lock()
+	response := peerVerdicts{Location: peers.Location, Verdicts: []PeerVerdict{}}
+	for _, verdict := range peers.local {
+		verdict.Age = time.Since(verdict.at).Seconds()
+		response.Verdicts = append(response.Verdicts, verdict)
+	}
+	peers.mu.Unlock()
+
+	sort.Slice(response.Verdicts, func(i, j int) bool { return response.Verdicts[i].Monitor < response.Verdicts[j].Monitor })
+	writeJSON(w, http.StatusOK, response)
+}
+
+// fetch gets the verdicts of a node
+func (peers *PeerGroup) fetch(ctx context.Context, node string) (*peerVerdicts, error) {
+	req, err := http.NewRequest("GET", strings.TrimRight(node, "/")+"/verdicts", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+peers.Secret)
+
+	res, err := peers.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New("unexpected status " + res.Status)
+	}
+
+	response := &peerVerdicts{}
+	if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+		return nil, err
+	}
+	if len(response.Location) == 0 || response.Location == peers.Location {
+		return nil, errors.New("node reports an invalid location '" + response.Location + "'")
+	}
+
+	return response, nil
+}
+
+// poll refreshes the verdicts of every node
+func (peers *PeerGroup) poll(ctx context.Context) {
+	for _, node := range peers.Nodes {
+		response, err := peers.fetch(ctx, node)
+		if err != nil {
+			logrus.Warnf("Could not get the verdicts of peer %s: %v", node, err)
+			continue
+		}
+
+		now := time.Now()
+		verdicts := map[string]PeerVerdict{}
+		for _, verdict := range response.Verdicts {
+			verdict.at = now.Add(-time.Duration(verdict.Age * float64(time.Second)))
+			verdicts[verdict.Monitor] = verdict
+		}
+
+		peers.mu.Lock()
+		if peers.remote == nil {
+			peers.remote = map[string]map[string]PeerVerdict{}
+		}
+		peers.remote[response.Location] = verdicts
+		peers.mu.Unlock()
+	}
+}
+
+func (peers *PeerGroup) run(ctx context.Context) {
+	defer close(peers.done)
+
+	ticker := time.NewTicker(peers.PollInterval * time.Second)
+	defer ticker.Stop()
+
+	for {
+		peers.poll(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Start serves the verdicts of this location and polls the other nodes, when nodes have been configured
+func (peers *PeerGroup) Start() error {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+
+	if !peers.enabled() || peers.done != nil {
+		return nil
+	}
+
+	if len(peers.Listen) > 0 {
+		ln, err := net.Listen("tcp", peers.Listen)
+		if err != nil {
+			return err
+		}
+
+		peers.server = &http.Server{Handler: peers}
+		go peers.server.Serve(ln)
+
+		logrus.Infof("Peer API listening on %s (location: %s)", ln.Addr(), peers.Location)
+	}
+
+	peers.client = &http.Client{Timeout: peers.PollInterval * time.Second}
+	var ctx context.Context
+	ctx, peers.cancel = context.WithCancel(context.Background())
+	peers.done = make(chan struct{})
+	go peers.run(ctx)
+
+	return nil
+}
+
+// Stop closes the listener and stops polling
+func (peers *PeerGroup) Stop() {
+	peers.mu.Lock()
+	if peers.server != nil {
+		peers.server.Close()
+		peers.server = nil
+	}
+	done := peers.done
+	if peers.cancel != nil {
+		peers.cancel()
+		peers.cancel = nil
+	}
+	peers.done = nil
+	peers.mu.Unlock()
+
+	if done != nil {
+		<-done
+	}
+}
+
+// applyQuorum shares the result of the check with the other locations and returns
+// the result agreed by a quorum of them, also electing the leader of the monitor
+func (mon *AbstractMonitor) applyQuorum(l *logrus.Entry, isUp bool) bool {
+	peers := &mon.config.Peers
+	if !peers.enabled() {
+		return isUp
+	}
+
+	failReason := ""
+	if !isUp {
+		failReason = mon.lastFailReason
+	}
+	peers.record(mon.Name, isUp, failReason)
+
+	// a location which missed two checks is considered gone
+	verdicts := peers.verdicts(mon.Name, (2*mon.Interval+peers.PollInterval)*time.Second)
+	locations := []string{}
+	down := []string{}
+	for location, verdict := range verdicts {
+		locations = append(locations, location)
+		if !verdict.Up {
+			if len(verdict.FailReason) > 0 {
+				down = append(down, location+" ("+verdict.FailReason+")")
+			} else {
+				down = append(down, location)
+			}
+		}
+	}
+	sort.Strings(locations)
+	sort.Strings(down)
+
+	leader := peerLeader(mon.Name, locations)
+	if leader != mon.peerLeader && leader == peers.Location && len(mon.peerLeader) > 0 {
+		// pick up the status and incident left by the previous leader before writing anything
+		if err := mon.ReloadCachetData(); err != nil {
+			l.Warnf("Cannot take over from leader %s, retrying on the next check: %v", mon.peerLeader, err)
+			leader = mon.peerLeader
+		}
+	}
+	if leader != mon.peerLeader {
+		l.Infof("Leader is now %s (locations: %s)", leader, strings.Join(locations, ", "))
+		mon.peerLeader = leader
+	}
+
+	// with fewer locations than the quorum, failures are confirmed by all the remaining ones
+	quorum := peers.Quorum
+	if len(locations) < quorum {
+		quorum = len(locations)
+		if !mon.peerDegraded {
+			l.Warnf("Only %d location(s) reporting (%s), below the quorum of %d: failures are confirmed by all of them", len(locations), strings.Join(locations, ", "), peers.Quorum)
+		}
+	} else if mon.peerDegraded {
+		l.Infof("Quorum of %d locations restored (locations: %s)", peers.Quorum, strings.Join(locations, ", "))
+	}
+	mon.peerDegraded = len(locations) < peers.Quorum
+
+	if len(down) >= quorum {
+		mon.lastFailReason = "down from " + strconv.Itoa(len(down)) + "/" + strconv.Itoa(len(locations)) + " locations: " + strings.Join(down, ", ")
+		return false
+	}
+
+	if !isUp {
+		l.Infof("Failure not confirmed by a quorum of locations (%d/%d down, quorum: %d)", len(down), len(locations), quorum)
+	}
+
+	return true
+}
+
+// isPeerLeader tells whether this location writes the status and incidents of the monitor
+func (mon *AbstractMonitor) isPeerLeader() bool {
+	return !mon.config.Peers.enabled() || mon.peerLeader == mon.config.Peers.Location
+}
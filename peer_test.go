@@ -0,0 +1,131 @@
+package cachet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+
+	"cachet/cachettest"
+)
+
+func TestPeerLeader(t *testing.T) {
+	leader := peerLeader("web", []string{"eu", "us", "asia"})
+	if leader != peerLeader("web", []string{"asia", "eu", "us"}) {
+		t.Error("expected the leader not to depend on the order of the locations")
+	}
+
+	others := []string{}
+	for _, location := range []string{"eu", "us", "asia"} {
+		if location != leader {
+			others = append(others, location)
+		}
+	}
+	if next := peerLeader("web", others); next == leader || len(next) == 0 {
+		t.Errorf("expected another leader once %s is gone, got %q", leader, next)
+	}
+}
+
+func TestPeerGroupValidate(t *testing.T) {
+	peers := &PeerGroup{Nodes: []string{"http://us:8090", "http://asia:8090"}, Secret: "s3cret"}
+	if errs := peers.Validate("eu"); len(errs) > 0 {
+		t.Errorf("expected a valid configuration, got %v", errs)
+	}
+	if peers.Location != "eu" || peers.Quorum != 2 {
+		t.Errorf("expected location eu and a quorum of 2, got %s and %d", peers.Location, peers.Quorum)
+	}
+
+	peers = &PeerGroup{Nodes: []string{"us:8090"}, Quorum: 3}
+	if errs := peers.Validate("eu"); len(errs) != 3 {
+		t.Errorf("expected secret, quorum and URL errors, got %v", errs)
+	}
+}
+
+func TestPeerQuorum(t *testing.T) {
+	l := logrus.WithFields(logrus.Fields{})
+
+	us := &PeerGroup{Location: "us", Secret: "s3cret", Nodes: []string{"http://eu"}}
+	us.record("web", false, "timeout")
+	srv := httptest.NewServer(us)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/verdicts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401 without the secret, got %d", res.StatusCode)
+	}
+
+	cfg := &CachetMonitor{Peers: PeerGroup{Secret: "s3cret", Nodes: []string{srv.URL}}}
+	cfg.Peers.Validate("eu")
+	cfg.Peers.client = http.DefaultClient
+	cfg.Peers.poll(context.Background())
+
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", Interval: 60, config: cfg}}
+	if !mon.applyQuorum(l, true) {
+		t.Error("expected the monitor to be up without a quorum of failures")
+	}
+
+	mon.lastFailReason = "connection refused"
+	if mon.applyQuorum(l, false) {
+		t.Error("expected the monitor to be down once both locations agree")
+	}
+	if !strings.Contains(mon.lastFailReason, "us (timeout)") || !strings.Contains(mon.lastFailReason, "eu (connection refused)") {
+		t.Errorf("expected the fail reason of each location, got %q", mon.lastFailReason)
+	}
+
+	if mon.peerLeader != peerLeader("web", []string{"eu", "us"}) {
+		t.Errorf("expected the leader to be elected, got %q", mon.peerLeader)
+	}
+	if mon.isPeerLeader() != (mon.peerLeader == "eu") {
+		t.Error("expected isPeerLeader to match the elected leader")
+	}
+}
+
+func TestPeerDegradedQuorum(t *testing.T) {
+	l := logrus.WithFields(logrus.Fields{})
+
+	// Cachet is unreachable at first
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	cfg := &CachetMonitor{
+		API:        CachetAPI{URL: down.URL, Token: "token", Retries: -1},
+		DateFormat: DefaultTimeFormat,
+		Peers:      PeerGroup{Secret: "s3cret", Nodes: []string{"http://us"}},
+	}
+	if errs := cfg.Peers.Validate("eu"); len(errs) > 0 || cfg.Peers.Quorum != 2 {
+		t.Fatalf("expected a quorum of 2, got %d (%v)", cfg.Peers.Quorum, errs)
+	}
+
+	// "us" led the monitor but stopped reporting
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", ComponentID: 1, Interval: 60, config: cfg, peerLeader: "us"}}
+
+	mon.lastFailReason = "connection refused"
+	if mon.applyQuorum(l, false) {
+		t.Error("expected the failure of the only remaining location to be confirmed")
+	}
+	if !mon.peerDegraded || !strings.Contains(mon.lastFailReason, "1/1 locations") {
+		t.Errorf("expected a degraded quorum, got %q", mon.lastFailReason)
+	}
+	if mon.isPeerLeader() {
+		t.Error("expected the lead not to be taken before the component has been reloaded")
+	}
+
+	fake := cachettest.NewServer()
+	defer fake.Close()
+	fake.AddComponent(cachettest.Component{Name: "Website", Enabled: true, Status: 4})
+	cfg.API = CachetAPI{URL: fake.URL, Token: fake.Token, Retries: -1}
+
+	if !mon.applyQuorum(l, true) || !mon.isPeerLeader() {
+		t.Fatalf("expected the lead to be taken once Cachet answers, leader: %q", mon.peerLeader)
+	}
+	if mon.currentStatus != 4 {
+		t.Errorf("expected the status left by the previous leader to be loaded, got %d", mon.currentStatus)
+	}
+}
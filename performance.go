@@ -0,0 +1,169 @@
+package cachet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const DefaultPerformanceStat = "median"
+
+// the baseline averages roughly this many performance windows
+const performanceBaselineWindows = 10
+
+func (mon *AbstractMonitor) performanceEnabled() bool {
+	return mon.PerformanceThreshold > 0 || mon.PerformanceThresholdMs > 0
+}
+
+// pushLag records the response time of a successful check
+func (mon *AbstractMonitor) pushLag(lag int64) {
+	if len(mon.lagHistory) >= mon.PerformanceWindow {
+		mon.lagHistory = mon.lagHistory[len(mon.lagHistory)-(mon.PerformanceWindow-1):]
+	}
+	mon.lagHistory = append(mon.lagHistory, lag)
+
+	// degraded samples are kept out of the baseline so it does not drift towards them
+	if mon.performanceDegraded {
+		return
+	}
+	if mon.lagBaseline == 0 {
+		mon.lagBaseline = float32(lag)
+		return
+	}
+	alpha := 2 / float32(performanceBaselineWindows*mon.PerformanceWindow+1)
+	mon.lagBaseline += alpha * (float32(lag) - mon.lagBaseline)
+}
+
+// lagPercentile returns the nearest-rank percentile of the lag history
+func (mon *AbstractMonitor) lagPercentile(p int) int64 {
+	if len(mon.lagHistory) == 0 {
+		return 0
+	}
+
+	sorted := make([]int64, len(mon.lagHistory))
+	copy(sorted, mon.lagHistory)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// performanceIssue returns a description of the exceeded limits, or an empty string
+func (mon *AbstractMonitor) performanceIssue() string {
+	stat := mon.lagPercentile(50)
+	if mon.PerformanceStat == "p95" {
+		stat = mon.lagPercentile(95)
+	}
+
+	issues := []string{}
+	if mon.PerformanceThresholdMs > 0 && stat > int64(mon.PerformanceThresholdMs) {
+		issues = append(issues, fmt.Sprintf("above %dms", mon.PerformanceThresholdMs))
+	}
+	if mon.PerformanceThreshold > 0 && mon.lagBaseline > 0 {
+		above := (float32(stat)/mon.lagBaseline - 1) * 100
+		if int(above) > mon.PerformanceThreshold {
+			issues = append(issues, fmt.Sprintf("%.0f%% above the %.0fms baseline (threshold=%d%%)", above, mon.lagBaseline, mon.PerformanceThreshold))
+		}
+	}
+
+	if len(issues) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("Response time %s is %dms over the last %d checks: %s", mon.PerformanceStat, stat, len(mon.lagHistory), strings.Join(issues, ", "))
+}
+
+// AnalysePerformance sets the component to "Performance Issues" when response times degrade and restores it on recovery
+func (mon *AbstractMonitor) AnalysePerformance(l *logrus.Entry) {
+	if !mon.performanceEnabled() {
+		return
+	}
+
+	if len(mon.lagHistory) < mon.PerformanceWindow {
+		l.Debugf("Response time history has not been yet saturated (stack: %d/%d)", len(mon.lagHistory), mon.PerformanceWindow)
+		return
+	}
+
+	issue := mon.performanceIssue()
+	if len(issue) > 0 {
+		// outages take precedence over performance issues
+		if mon.isPartial() || mon.isCritical() || (mon.incident != nil && !mon.incidentIsPerformance) {
+			return
+		}
+
+		if !mon.performanceDegraded {
+			l.Warnf("performance degraded: %s", issue)
+		}
+		mon.performanceDegraded = true
+
+		if mon.PerformanceIncident && mon.incident == nil {
+			tplData := getTemplateData(mon)
+			tplData["FailReason"] = issue
+
+			subject, message := mon.Template.Investigating.Exec(tplData)
+			mon.incident = &Incident{
+				Name:            subject,
+				ComponentID:     mon.ComponentID,
+				Message:         message,
+				Notify:          true,
+				ComponentStatus: 2,
+			}
+			mon.incidentIsPerformance = true
+
+			l.Warnf("creating performance incident")
+			mon.incident.SetInvestigating()
+			if err := mon.incident.Send(mon.apiContext(), mon.config); err != nil {
+				l.Printf("Error sending incident: %v", err)
+				// retry on next tick
+				mon.incident = nil
+				mon.incidentIsPerformance = false
+			} else {
+				promIncidentsOpened.add(1, mon.promLabelValues()...)
+			}
+		}
+
+		if !mon.isDegraded() {
+			mon.setComponentStatus(l, 2)
+		}
+		return
+	}
+
+	if !mon.performanceDegraded {
+		return
+	}
+
+	l.Infof("performance recovered")
+	mon.performanceDegraded = false
+
+	if mon.incident != nil && mon.incidentIsPerformance {
+		l.Infof("Resolving performance incident %d", mon.incident.ID)
+
+		tplData := getTemplateData(mon)
+		tplData["incident"] = mon.incident
+
+		subject, message := mon.Template.Fixed.Exec(tplData)
+		mon.incident.Name = subject
+		mon.incident.Message = message
+		mon.incident.SetFixed()
+		if err := mon.incident.Send(mon.apiContext(), mon.config); err != nil {
+			l.Warnf("Error updating sending incident: %v", err)
+		} else {
+			promIncidentsResolved.add(1, mon.promLabelValues()...)
+		}
+
+		mon.incident = nil
+		mon.incidentIsPerformance = false
+		mon.currentStatus = 1
+		return
+	}
+
+	if mon.isDegraded() {
+		mon.setComponentStatus(l, 1)
+	}
+}
@@ -0,0 +1,80 @@
+package cachet
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLagPercentile(t *testing.T) {
+	mon := &AbstractMonitor{PerformanceWindow: 10}
+	for _, lag := range []int64{50, 10, 40, 20, 30, 60, 70, 80, 100, 90} {
+		mon.pushLag(lag)
+	}
+
+	if median := mon.lagPercentile(50); median != 50 {
+		t.Errorf("expected median of 50, got %d", median)
+	}
+	if p95 := mon.lagPercentile(95); p95 != 100 {
+		t.Errorf("expected p95 of 100, got %d", p95)
+	}
+
+	mon.pushLag(1000)
+	if len(mon.lagHistory) != mon.PerformanceWindow {
+		t.Errorf("lag history should be capped to %d, got %d", mon.PerformanceWindow, len(mon.lagHistory))
+	}
+}
+
+func TestPerformanceIssue(t *testing.T) {
+	mon := &AbstractMonitor{PerformanceWindow: 5, PerformanceStat: "median", PerformanceThresholdMs: 200}
+	for i := 0; i < 5; i++ {
+		mon.pushLag(100)
+	}
+	if issue := mon.performanceIssue(); len(issue) > 0 {
+		t.Errorf("no issue expected under the absolute threshold, got %q", issue)
+	}
+
+	mon.PerformanceThresholdMs = 0
+	mon.PerformanceThreshold = 50
+	for i := 0; i < 3; i++ {
+		mon.pushLag(300)
+	}
+	if issue := mon.performanceIssue(); len(issue) == 0 {
+		t.Error("issue expected when the median is well above the baseline")
+	}
+}
+
+func TestAnalysePerformance(t *testing.T) {
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", ComponentID: 1, ThresholdCount: 2, HistorySize: 4, PerformanceThresholdMs: 200, PerformanceWindow: 3}}
+	cfg := &CachetMonitor{Monitors: []MonitorInterface{mon}}
+
+	// slow on the 4th and 5th checks, down on the 9th and 10th, then slow while the history recovers
+	checks := simulatedChecks("uuuuuuuudduuu")
+	for i, lag := range []int64{100, 100, 100, 300, 300, 100, 100, 100, 0, 0, 300, 300, 100} {
+		checks[i].LatencyMs = lag
+	}
+
+	simulation, err := cfg.Simulate("", checks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := []string{}
+	for _, event := range simulation.Events {
+		events = append(events, strings.SplitN(event.String(), ":", 2)[0])
+	}
+	// the slow checks during the outage must not downgrade it to performance issues
+	expected := []string{
+		"component => performance issues", "component => operational",
+		"incident #1 created", "component => partial outage", "component => major outage",
+		"incident #1 fixed", "component => operational", "component => performance issues",
+	}
+	if strings.Join(events, ", ") != strings.Join(expected, ", ") {
+		t.Fatalf("expected %v, got %v", expected, events)
+	}
+
+	start := checks[0].Time
+	if degraded, recovered := simulation.Events[0].Time.Sub(start), simulation.Events[1].Time.Sub(start); degraded != 4*time.Minute || recovered != 6*time.Minute {
+		t.Errorf("expected performance issues from 4 to 6 minutes, got %v to %v", degraded, recovered)
+	}
+}
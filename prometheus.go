@@ -0,0 +1,253 @@
+package cachet
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// default Prometheus histogram buckets (seconds)
+var promDefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var promMonitorLabels = []string{"monitor", "type", "component_id"}
+
+var (
+	promChecks = newPromMetric("cachet_monitor_checks_total", "counter",
+		"Checks run, by result.", append(promMonitorLabels, "result")...)
+	promCheckDuration = newPromMetric("cachet_monitor_check_duration_seconds", "histogram",
+		"Duration of the checks.", promMonitorLabels...)
+	promIncidentsOpened = newPromMetric("cachet_monitor_incidents_opened_total", "counter",
+		"Incidents created in Cachet.", promMonitorLabels...)
+	promIncidentsResolved = newPromMetric("cachet_monitor_incidents_resolved_total", "counter",
+		"Incidents resolved in Cachet.", promMonitorLabels...)
+	promShellHookFailures = newPromMetric("cachet_monitor_shellhook_failures_total", "counter",
+		"Shell hooks which exited with an error.", append(promMonitorLabels, "hook")...)
+	promAPIRequests = newPromMetric("cachet_monitor_api_requests_total", "counter",
+		"Requests sent to the Cachet API (including retries), by status code.", "method", "endpoint", "code")
+	promAPIDuration = newPromMetric("cachet_monitor_api_request_duration_seconds", "histogram",
+		"Latency of the Cachet API requests.", "method", "endpoint")
+	promAPIErrors = newPromMetric("cachet_monitor_api_errors_total", "counter",
+		"Cachet API calls which failed after retries.", "method", "endpoint")
+)
+
+// exposed in this order, after the per-monitor gauges
+var promMetrics = []*promMetric{
+	promChecks,
+	promCheckDuration,
+	promIncidentsOpened,
+	promIncidentsResolved,
+	promShellHookFailures,
+	promAPIRequests,
+	promAPIDuration,
+	promAPIErrors,
+}
+
+// promMetric is a counter, gauge or histogram in the Prometheus text exposition format
+type promMetric struct {
+	name       string
+	kind       string
+	help       string
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*promSeries
+}
+
+type promSeries struct {
+	labelValues []string
+	value       float64
+	// histograms only, counts are per bucket (not cumulative)
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newPromMetric(name, kind, help string, labelNames ...string) *promMetric {
+	metric := &promMetric{
+		name:       name,
+		kind:       kind,
+		help:       help,
+		labelNames: labelNames,
+		series:     map[string]*promSeries{},
+	}
+	if kind == "histogram" {
+		metric.buckets = promDefaultBuckets
+	}
+
+	return metric
+}
+
+func (metric *promMetric) get(labelValues []string) *promSeries {
+	key := strings.Join(labelValues, "\x00")
+	series, ok := metric.series[key]
+	if !ok {
+		series = &promSeries{labelValues: labelValues}
+		if metric.kind == "histogram" {
+			series.counts = make([]uint64, len(metric.buckets))
+		}
+		metric.series[key] = series
+	}
+
+	return series
+}
+
+func (metric *promMetric) add(value float64, labelValues ...string) {
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+
+	metric.get(labelValues).value += value
+}
+
+func (metric *promMetric) set(value float64, labelValues ...string) {
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+
+	metric.get(labelValues).value = value
+}
+
+func (metric *promMetric) observe(value float64, labelValues ...string) {
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+
+	series := metric.get(labelValues)
+	for i, bound := range metric.buckets {
+		if value <= bound {
+			series.counts[i]++
+			break
+		}
+	}
+	series.sum += value
+	series.count++
+}
+
+func promLabels(names, values []string, extra ...string) string {
+	pairs := []string{}
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+promEscape(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+promEscape(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func promEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func promFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// write outputs the metric, series are sorted to keep the output stable
+func (metric *promMetric) write(w io.Writer) {
+	metric.mu.Lock()
+	defer metric.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.kind)
+
+	keys := []string{}
+	for key := range metric.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := metric.series[key]
+		if metric.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", metric.name, promLabels(metric.labelNames, series.labelValues), promFloat(series.value))
+			continue
+		}
+
+		cumulative := uint64(0)
+		for i, bound := range metric.buckets {
+			cumulative += series.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", metric.name, promLabels(metric.labelNames, series.labelValues, "le", promFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", metric.name, promLabels(metric.labelNames, series.labelValues, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", metric.name, promLabels(metric.labelNames, series.labelValues), promFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", metric.name, promLabels(metric.labelNames, series.labelValues), series.count)
+	}
+}
+
+// promLabelValues returns the monitor, type and component_id labels
+func (mon *AbstractMonitor) promLabelValues(extra ...string) []string {
+	return append([]string{mon.Name, mon.Type, strconv.Itoa(mon.ComponentID)}, extra...)
+}
+
+// promEndpoint removes IDs and query strings so API paths can be used as labels
+func promEndpoint(url string) string {
+	if i := strings.Index(url, "?"); i >= 0 {
+		url = url[:i]
+	}
+
+	parts := strings.Split(url, "/")
+	for i, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil {
+			parts[i] = ":id"
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// writePrometheus outputs the state of the monitors and all the collected metrics
+func writePrometheus(w io.Writer, monitors []MonitorInterface) {
+	success := newPromMetric("cachet_monitor_check_success", "gauge",
+		"Result of the last check (1 = up, 0 = down).", promMonitorLabels...)
+	status := newPromMetric("cachet_monitor_component_status", "gauge",
+		"Cachet status of the component (1 = operational, 2 = performance issues, 3 = partial outage, 4 = major outage).", promMonitorLabels...)
+	enabled := newPromMetric("cachet_monitor_enabled", "gauge",
+		"Whether checks are running (0 when disabled or paused).", promMonitorLabels...)
+	maintenance := newPromMetric("cachet_monitor_in_maintenance", "gauge",
+		"Whether a maintenance window is active.", promMonitorLabels...)
+
+	for _, monitor := range monitors {
+		mon := monitor.GetMonitor()
+		state := mon.Status()
+		labels := mon.promLabelValues()
+
+		if len(state.History) > 0 {
+			up := 0.0
+			if state.History[len(state.History)-1] {
+				up = 1
+			}
+			success.set(up, labels...)
+		}
+		if state.Status > 0 {
+			status.set(float64(state.Status), labels...)
+		}
+		isEnabled := 0.0
+		if state.Running && state.Enabled {
+			isEnabled = 1
+		}
+		enabled.set(isEnabled, labels...)
+		inMaintenance := 0.0
+		if state.InMaintenance {
+			inMaintenance = 1
+		}
+		maintenance.set(inMaintenance, labels...)
+	}
+
+	for _, metric := range append([]*promMetric{success, status, enabled, maintenance}, promMetrics...) {
+		metric.write(w)
+	}
+}
+
+func (srv *AdminServer) servePrometheus(w http.ResponseWriter, r *http.Request) {
+	srv.mu.Lock()
+	monitors := srv.monitors
+	srv.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writePrometheus(w, monitors)
+}
@@ -0,0 +1,65 @@
+package cachet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPromMetricWrite(t *testing.T) {
+	counter := newPromMetric("test_total", "counter", "Test counter.", "name")
+	counter.add(1, `a"b`)
+	counter.add(2, `a"b`)
+
+	histogram := newPromMetric("test_seconds", "histogram", "Test histogram.", "name")
+	histogram.observe(0.02, "x")
+	histogram.observe(3, "x")
+
+	out := new(bytes.Buffer)
+	counter.write(out)
+	histogram.write(out)
+
+	for _, line := range []string{
+		"# TYPE test_total counter",
+		`test_total{name="a\"b"} 3`,
+		`test_seconds_bucket{name="x",le="0.01"} 0`,
+		`test_seconds_bucket{name="x",le="0.025"} 1`,
+		`test_seconds_bucket{name="x",le="5"} 2`,
+		`test_seconds_bucket{name="x",le="+Inf"} 2`,
+		`test_seconds_sum{name="x"} 3.02`,
+		`test_seconds_count{name="x"} 2`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestPromEndpoint(t *testing.T) {
+	if endpoint := promEndpoint("/components/12"); endpoint != "/components/:id" {
+		t.Errorf("expected /components/:id, got %s", endpoint)
+	}
+	if endpoint := promEndpoint("/incidents?component_id=3&sort=id"); endpoint != "/incidents" {
+		t.Errorf("expected /incidents, got %s", endpoint)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", Type: "mock", ComponentID: 4, Enabled: true}}
+	mon.history = []bool{true, false}
+	mon.currentStatus = 3
+	mon.publishStatus(mon)
+
+	out := new(bytes.Buffer)
+	writePrometheus(out, []MonitorInterface{mon})
+
+	for _, line := range []string{
+		`cachet_monitor_check_success{monitor="web",type="mock",component_id="4"} 0`,
+		`cachet_monitor_component_status{monitor="web",type="mock",component_id="4"} 3`,
+		`cachet_monitor_enabled{monitor="web",type="mock",component_id="4"} 1`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out.String())
+		}
+	}
+}
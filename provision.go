@@ -0,0 +1,405 @@
+package cachet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/mitchellh/mapstructure"
+)
+
+// ComponentRef references a Cachet component by name, created when missing
+type ComponentRef struct {
+	Name string
+	// component group name, created when missing (optional)
+	Group       string
+	Description string
+	Link        string
+}
+
+// MetricRef references a Cachet metric by name, created when missing
+type MetricRef struct {
+	Name        string
+	Suffix      string
+	Description string
+	// sum (default) or average
+	CalcType string `mapstructure:"calc_type"`
+}
+
+// ComponentGroup Cachet data model
+type ComponentGroup struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Metric Cachet data model
+type Metric struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Suffix      string `json:"suffix"`
+	Description string `json:"description"`
+	CalcType    int    `json:"calc_type"`
+}
+
+// Cachet metric calculation types
+var metricCalcTypes = map[string]int{
+	"sum":     0,
+	"average": 1,
+}
+
+// provisionCache keeps the IDs resolved by name across reloads
+type provisionCache struct {
+	mu         sync.Mutex
+	groups     map[string]int
+	components map[string]int
+	metrics    map[string]int
+}
+
+// SplitMetricRefs moves the metrics referenced by name ({name, suffix, calc_type}) out of a raw monitor
+// configuration, so the remaining settings (metric IDs included) can be decoded as before
+func SplitMetricRefs(raw map[string]interface{}) (map[string]interface{}, map[string][]MetricRef, error) {
+	var metrics map[string]interface{}
+	for key, value := range raw {
+		if strings.ToLower(key) != "metrics" {
+			continue
+		}
+		if err := mapstructure.Decode(value, &metrics); err != nil {
+			return nil, nil, err
+		}
+	}
+	if metrics == nil {
+		return raw, nil, nil
+	}
+
+	refs := map[string][]MetricRef{}
+	ids := map[string]interface{}{}
+	for kind, value := range metrics {
+		values, ok := value.([]interface{})
+		if !ok {
+			switch value.(type) {
+			case map[string]interface{}, map[interface{}]interface{}:
+				values = []interface{}{value}
+			default:
+				ids[kind] = value
+				continue
+			}
+		}
+
+		kept := []interface{}{}
+		for _, v := range values {
+			switch v.(type) {
+			case map[string]interface{}, map[interface{}]interface{}:
+				var ref MetricRef
+				if err := mapstructure.Decode(v, &ref); err != nil {
+					return nil, nil, errors.New("metric " + kind + ": " + err.Error())
+				}
+				refs[kind] = append(refs[kind], ref)
+			default:
+				kept = append(kept, v)
+			}
+		}
+		ids[kind] = kept
+	}
+	if len(refs) == 0 {
+		return raw, nil, nil
+	}
+
+	settings := map[string]interface{}{}
+	for key, value := range raw {
+		settings[key] = value
+		if strings.ToLower(key) == "metrics" {
+			settings[key] = ids
+		}
+	}
+
+	return settings, refs, nil
+}
+
+// metricIDs returns the metric IDs of a kind of metric
+func (mon *AbstractMonitor) metricIDs(kind string) *[]int {
+	switch kind {
+	case "response_time":
+		return &mon.Metrics.ResponseTime
+	case "availability":
+		return &mon.Metrics.Availability
+	case "incident_count":
+		return &mon.Metrics.IncidentCount
+	case "days_remaining":
+		return &mon.Metrics.DaysRemaining
+	}
+
+	return nil
+}
+
+// validateRefs checks the components and metrics referenced by name
+func (mon *AbstractMonitor) validateRefs() []string {
+	errs := []string{}
+
+	if mon.ComponentID > 0 && len(mon.Component.Name) > 0 {
+		errs = append(errs, "component_id & component cannot both be set")
+	}
+	if len(mon.Component.Name) == 0 && (len(mon.Component.Group) > 0 || len(mon.Component.Description) > 0 || len(mon.Component.Link) > 0) {
+		errs = append(errs, "component name has not been set")
+	}
+
+	for kind, refs := range mon.MetricRefs {
+		if mon.metricIDs(kind) == nil {
+			errs = append(errs, "Unsupported metric '"+kind+"'")
+		}
+		for _, ref := range refs {
+			if len(ref.Name) == 0 {
+				errs = append(errs, "Metric "+kind+" has no name")
+			}
+			if _, ok := metricCalcTypes[strings.ToLower(ref.CalcType)]; !ok && len(ref.CalcType) > 0 {
+				errs = append(errs, "Metric "+ref.Name+": unsupported calc_type '"+ref.CalcType+"' (sum or average)")
+			}
+		}
+	}
+
+	return errs
+}
+
+// FindComponentGroup returns the component group with this exact name, or ErrNotFound
+func (api *CachetAPI) FindComponentGroup(ctx context.Context, name string) (ComponentGroup, error) {
+	groups := []ComponentGroup{}
+	if err := api.find(ctx, "/components/groups", name, &groups); err != nil {
+		return ComponentGroup{}, err
+	}
+
+	for _, group := range groups {
+		if group.Name == name {
+			return group, nil
+		}
+	}
+
+	return ComponentGroup{}, ErrNotFound
+}
+
+// CreateComponentGroup creates a component group
+func (api *CachetAPI) CreateComponentGroup(ctx context.Context, name string) (ComponentGroup, error) {
+	var group ComponentGroup
+	err := api.create(ctx, "/components/groups", map[string]interface{}{"name": name}, &group)
+
+	return group, err
+}
+
+// FindComponent returns the component with this exact name in the group (0 for any group), or ErrNotFound
+func (api *CachetAPI) FindComponent(ctx context.Context, name string, groupID int) (Component, error) {
+	components := []Component{}
+	if err := api.find(ctx, "/components", name, &components); err != nil {
+		return Component{}, err
+	}
+
+	for _, component := range components {
+		if component.Name == name && (groupID == 0 || component.GroupID == groupID) {
+			return component, nil
+		}
+	}
+
+	return Component{}, ErrNotFound
+}
+
+// CreateComponent creates an operational component
+func (api *CachetAPI) CreateComponent(ctx context.Context, ref ComponentRef, groupID int) (Component, error) {
+	var component Component
+	err := api.create(ctx, "/components", map[string]interface{}{
+		"name":        ref.Name,
+		"description": ref.Description,
+		"link":        ref.Link,
+		"group_id":    groupID,
+		"status":      1,
+		"enabled":     true,
+	}, &component)
+
+	return component, err
+}
+
+// FindMetric returns the metric with this exact name, or ErrNotFound
+func (api *CachetAPI) FindMetric(ctx context.Context, name string) (Metric, error) {
+	metrics := []Metric{}
+	if err := api.find(ctx, "/metrics", name, &metrics); err != nil {
+		return Metric{}, err
+	}
+
+	for _, metric := range metrics {
+		if metric.Name == name {
+			return metric, nil
+		}
+	}
+
+	return Metric{}, ErrNotFound
+}
+
+// CreateMetric creates a metric shown on the status page
+func (api *CachetAPI) CreateMetric(ctx context.Context, ref MetricRef) (Metric, error) {
+	description := ref.Description
+	if len(description) == 0 {
+		description = ref.Name
+	}
+
+	var metric Metric
+	err := api.create(ctx, "/metrics", map[string]interface{}{
+		"name":          ref.Name,
+		"suffix":        ref.Suffix,
+		"description":   description,
+		"calc_type":     metricCalcTypes[strings.ToLower(ref.CalcType)],
+		"default_value": 0,
+		"display_chart": 1,
+	}, &metric)
+
+	return metric, err
+}
+
+// find lists the resources filtered by name
+func (api *CachetAPI) find(ctx context.Context, path string, name string, v interface{}) error {
+	query := url.Values{}
+	query.Set("name", name)
+	query.Set("per_page", "100")
+
+	_, body, err := api.NewRequest(ctx, "GET", path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body.Data, v)
+}
+
+func (api *CachetAPI) create(ctx context.Context, path string, data map[string]interface{}, v interface{}) error {
+	jsonBytes, _ := json.Marshal(data)
+
+	_, body, err := api.NewRequest(ctx, "POST", path, jsonBytes)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body.Data, v)
+}
+
+func (cfg *CachetMonitor) resolveGroup(ctx context.Context, name string) (int, error) {
+	if id, ok := cfg.provisioned.groups[name]; ok {
+		return id, nil
+	}
+
+	group, err := cfg.API.FindComponentGroup(ctx, name)
+	if err == ErrNotFound && !cfg.NoCreate {
+		if group, err = cfg.API.CreateComponentGroup(ctx, name); err == nil {
+			logrus.Infof("Created component group '%s' (ID: %d)", name, group.ID)
+		}
+	}
+	if err != nil {
+		return 0, errors.New("component group '" + name + "': " + err.Error())
+	}
+
+	cfg.provisioned.groups[name] = group.ID
+
+	return group.ID, nil
+}
+
+func (cfg *CachetMonitor) resolveComponent(ctx context.Context, ref ComponentRef) (int, error) {
+	key := ref.Group + "/" + ref.Name
+	if id, ok := cfg.provisioned.components[key]; ok {
+		return id, nil
+	}
+
+	groupID := 0
+	if len(ref.Group) > 0 {
+		var err error
+		if groupID, err = cfg.resolveGroup(ctx, ref.Group); err != nil {
+			return 0, err
+		}
+	}
+
+	component, err := cfg.API.FindComponent(ctx, ref.Name, groupID)
+	if err == ErrNotFound && !cfg.NoCreate {
+		if component, err = cfg.API.CreateComponent(ctx, ref, groupID); err == nil {
+			logrus.Infof("Created component '%s' (ID: %d)", ref.Name, component.ID)
+		}
+	}
+	if err != nil {
+		return 0, errors.New("component '" + ref.Name + "': " + err.Error())
+	}
+
+	cfg.provisioned.components[key] = component.ID
+
+	return component.ID, nil
+}
+
+func (cfg *CachetMonitor) resolveMetric(ctx context.Context, ref MetricRef) (int, error) {
+	if id, ok := cfg.provisioned.metrics[ref.Name]; ok {
+		return id, nil
+	}
+
+	metric, err := cfg.API.FindMetric(ctx, ref.Name)
+	if err == ErrNotFound && !cfg.NoCreate {
+		if metric, err = cfg.API.CreateMetric(ctx, ref); err == nil {
+			logrus.Infof("Created metric '%s' (ID: %d)", ref.Name, metric.ID)
+		}
+	}
+	if err != nil {
+		return 0, errors.New("metric '" + ref.Name + "': " + err.Error())
+	}
+
+	cfg.provisioned.metrics[ref.Name] = metric.ID
+
+	return metric.ID, nil
+}
+
+// Provision resolves the components and metrics the monitors reference by name to their IDs,
+// creating the missing ones unless NoCreate is set. Resolved IDs are cached.
+func (cfg *CachetMonitor) Provision(ctx context.Context, monitors []MonitorInterface) error {
+	cfg.provisioned.mu.Lock()
+	defer cfg.provisioned.mu.Unlock()
+
+	if cfg.provisioned.components == nil {
+		cfg.provisioned.groups = map[string]int{}
+		cfg.provisioned.components = map[string]int{}
+		cfg.provisioned.metrics = map[string]int{}
+	}
+
+	errs := []string{}
+	for _, monitor := range monitors {
+		mon := monitor.GetMonitor()
+
+		if len(mon.Component.Name) > 0 {
+			id, err := cfg.resolveComponent(ctx, mon.Component)
+			if err != nil {
+				errs = append(errs, mon.Name+": "+err.Error())
+			} else {
+				logrus.Debugf("Monitor %s: component '%s' is ID %d", mon.Name, mon.Component.Name, id)
+				mon.ComponentID = id
+			}
+		}
+
+		kinds := []string{}
+		for kind := range mon.MetricRefs {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			ids := mon.metricIDs(kind)
+			for _, ref := range mon.MetricRefs[kind] {
+				id, err := cfg.resolveMetric(ctx, ref)
+				if err != nil {
+					errs = append(errs, mon.Name+": "+err.Error())
+					continue
+				}
+				logrus.Debugf("Monitor %s: %s metric '%s' is ID %d", mon.Name, kind, ref.Name, id)
+				if !contains(*ids, id) {
+					*ids = append(*ids, id)
+				}
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errors.New("Could not provision " + strconv.Itoa(len(errs)) + " reference(s):\n - " + strings.Join(errs, "\n - "))
+	}
+
+	return nil
+}
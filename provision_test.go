@@ -0,0 +1,129 @@
+package cachet
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+
+	"cachet/cachettest"
+)
+
+func TestSplitMetricRefs(t *testing.T) {
+	raw := map[string]interface{}{
+		"name": "web",
+		"metrics": map[interface{}]interface{}{
+			"response_time":  map[interface{}]interface{}{"name": "Web latency", "suffix": "ms", "calc_type": "average"},
+			"availability":   []interface{}{3, map[interface{}]interface{}{"name": "Web availability"}},
+			"incident_count": []interface{}{4},
+		},
+	}
+
+	settings, refs, err := SplitMetricRefs(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs["response_time"]) != 1 || refs["response_time"][0].Suffix != "ms" || refs["response_time"][0].CalcType != "average" {
+		t.Errorf("expected the response_time reference, got %v", refs["response_time"])
+	}
+	if len(refs["availability"]) != 1 || refs["availability"][0].Name != "Web availability" {
+		t.Errorf("expected the availability reference, got %v", refs["availability"])
+	}
+
+	var mon AbstractMonitor
+	if err := mapstructure.Decode(settings, &mon); err != nil {
+		t.Fatalf("expected the remaining settings to decode, got %v", err)
+	}
+	if len(mon.Metrics.Availability) != 1 || mon.Metrics.Availability[0] != 3 || len(mon.Metrics.IncidentCount) != 1 {
+		t.Errorf("expected the metric IDs to be kept, got %+v", mon.Metrics)
+	}
+
+	if settings, refs, _ := SplitMetricRefs(map[string]interface{}{"name": "web"}); refs != nil || settings["name"] != "web" {
+		t.Error("expected a configuration without references to be unchanged")
+	}
+}
+
+// newProvisioningServer serves component 1 (Website) and metric 5 (Latency)
+func newProvisioningServer() *cachettest.Server {
+	fake := cachettest.NewServer()
+	fake.AddComponent(cachettest.Component{Name: "Website", Enabled: true})
+	fake.AddMetric(cachettest.Metric{ID: 5, Name: "Latency"})
+
+	return fake
+}
+
+// created lists the resources created through the API, as "path name"
+func created(fake *cachettest.Server) []string {
+	resources := []string{}
+	for _, request := range fake.Requests() {
+		if request.Method != "POST" {
+			continue
+		}
+		var data struct {
+			Name string `json:"name"`
+		}
+		json.Unmarshal([]byte(request.Body), &data)
+		resources = append(resources, request.URL+" "+data.Name)
+	}
+
+	return resources
+}
+
+func TestProvision(t *testing.T) {
+	fake := newProvisioningServer()
+	defer fake.Close()
+
+	cfg := &CachetMonitor{API: CachetAPI{URL: fake.URL, Token: fake.Token, retryWait: time.Millisecond}}
+	web := &MockMonitor{AbstractMonitor: AbstractMonitor{
+		Name:       "web",
+		Component:  ComponentRef{Name: "Website"},
+		MetricRefs: map[string][]MetricRef{"response_time": {{Name: "Latency"}}},
+	}}
+	api := &MockMonitor{AbstractMonitor: AbstractMonitor{
+		Name:       "api",
+		Component:  ComponentRef{Name: "API", Group: "Backend"},
+		MetricRefs: map[string][]MetricRef{"availability": {{Name: "API availability"}}},
+	}}
+
+	if err := cfg.Provision(context.Background(), []MonitorInterface{web, api}); err != nil {
+		t.Fatal(err)
+	}
+	if web.ComponentID != 1 || len(web.Metrics.ResponseTime) != 1 || web.Metrics.ResponseTime[0] != 5 {
+		t.Errorf("expected existing component 1 and metric 5, got %d and %v", web.ComponentID, web.Metrics.ResponseTime)
+	}
+	if resources := created(fake); strings.Join(resources, ", ") != "/components/groups Backend, /components API, /metrics API availability" {
+		t.Errorf("unexpected created resources: %v", resources)
+	}
+	if component, _ := fake.Component(api.ComponentID); component.Name != "API" || component.GroupID != fake.Groups()[0].ID {
+		t.Errorf("expected the component to be created in the group, got %+v", component)
+	}
+	if metrics := fake.Metrics(); len(api.Metrics.Availability) != 1 || api.Metrics.Availability[0] != metrics[1].ID {
+		t.Errorf("expected the created metric ID to be set, got %v", api.Metrics.Availability)
+	}
+
+	// resolved IDs are cached
+	fake.Close()
+	again := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "api", Component: ComponentRef{Name: "API", Group: "Backend"}}}
+	if err := cfg.Provision(context.Background(), []MonitorInterface{again}); err != nil || again.ComponentID != api.ComponentID {
+		t.Errorf("expected the cached component ID %d, got %d (%v)", api.ComponentID, again.ComponentID, err)
+	}
+}
+
+func TestProvisionNoCreate(t *testing.T) {
+	fake := newProvisioningServer()
+	defer fake.Close()
+
+	cfg := &CachetMonitor{API: CachetAPI{URL: fake.URL, Token: fake.Token, retryWait: time.Millisecond}, NoCreate: true}
+	mon := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "api", Component: ComponentRef{Name: "API"}}}
+
+	err := cfg.Provision(context.Background(), []MonitorInterface{mon})
+	if err == nil || !strings.Contains(err.Error(), "component 'API'") {
+		t.Errorf("expected a missing component error, got %v", err)
+	}
+	if resources := created(fake); len(resources) > 0 {
+		t.Errorf("expected nothing to be created, got %v", resources)
+	}
+}
@@ -0,0 +1,357 @@
+package cachet
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const DefaultQueueMaxSize = 10000
+
+// name of the write-ahead log inside the queue directory
+const queueFileName = "queue.jsonl"
+
+// how often the queue checks whether Cachet is reachable again
+const queueReplayInterval = 15 * time.Second
+
+const (
+	QueueEntryMetric          = "metric"
+	QueueEntryComponentStatus = "component_status"
+)
+
+// QueueEntry is an API write which could not be delivered
+type QueueEntry struct {
+	Type      string  `json:"type"`
+	ID        int     `json:"id"`
+	Value     float64 `json:"value,omitempty"`
+	Status    int     `json:"status,omitempty"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// OfflineQueue buffers failed metric points and component status updates on disk
+// and replays them in order once Cachet responds to a ping again
+type OfflineQueue struct {
+	// disabled when empty
+	Directory string `json:"directory" yaml:"directory"`
+	// maximum number of queued writes, the oldest are dropped first
+	MaxSize int `json:"max_size" yaml:"max_size"`
+
+	mu sync.Mutex
+	// serializes replays
+	replaying sync.Mutex
+	api       *CachetAPI
+	entries   []QueueEntry
+	// entries written to the file, including delivered and superseded ones
+	fileEntries int
+	file        *os.File
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// Open loads the writes left over by a previous run and starts replaying them in the background.
+// The queue stays disabled during a dry run.
+func (q *OfflineQueue) Open(api *CachetAPI) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.Directory) == 0 || q.file != nil {
+		return nil
+	}
+	if api.DryRun != nil {
+		// replaying would record the writes left by a real run as delivered, and remove them
+		logrus.Infof("Dry run: offline queue %s left untouched", q.path())
+		return nil
+	}
+
+	if q.MaxSize <= 0 {
+		q.MaxSize = DefaultQueueMaxSize
+	}
+
+	if err := os.MkdirAll(q.Directory, 0700); err != nil {
+		return err
+	}
+
+	entries, err := readQueueFile(q.path())
+	if err != nil {
+		return err
+	}
+
+	q.api = api
+	q.entries = nil
+	for _, entry := range entries {
+		q.add(entry)
+	}
+	if err := q.compact(); err != nil {
+		return err
+	}
+	if len(q.entries) > 0 {
+		logrus.Infof("Offline queue: %d writes left over from a previous run", len(q.entries))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	q.done = make(chan struct{})
+	go q.run(ctx)
+
+	return nil
+}
+
+// Close stops the replay and closes the queue file, queued writes are kept for the next run
+func (q *OfflineQueue) Close() {
+	q.mu.Lock()
+	if q.file == nil {
+		q.mu.Unlock()
+		return
+	}
+	q.cancel()
+	q.mu.Unlock()
+
+	<-q.done
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.file.Close()
+	q.file = nil
+}
+
+// Len returns the number of writes waiting to be delivered
+func (q *OfflineQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries)
+}
+
+// Push stores a failed write, returns false when the queue is disabled
+func (q *OfflineQueue) Push(entry QueueEntry) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file == nil {
+		return false
+	}
+
+	q.add(entry)
+	if err := q.append(entry); err != nil {
+		logrus.Warnf("Offline queue: cannot write to %s: %v", q.path(), err)
+	}
+
+	return true
+}
+
+// Delivered must be called when a component status has been set directly,
+// so older queued statuses do not overwrite it on replay (or after a restart)
+func (q *OfflineQueue) Delivered(componentID int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued := len(q.entries)
+	q.entries = supersedeStatus(q.entries, componentID)
+	if q.file == nil || len(q.entries) == queued {
+		return
+	}
+
+	if err := q.compact(); err != nil {
+		logrus.Warnf("Offline queue: cannot rewrite %s: %v", q.path(), err)
+	}
+}
+
+func (q *OfflineQueue) path() string {
+	return filepath.Join(q.Directory, queueFileName)
+}
+
+// add appends an entry in memory, coalescing status updates and enforcing MaxSize
+func (q *OfflineQueue) add(entry QueueEntry) {
+	if entry.Type == QueueEntryComponentStatus {
+		q.entries = supersedeStatus(q.entries, entry.ID)
+	}
+	q.entries = append(q.entries, entry)
+
+	if dropped := len(q.entries) - q.MaxSize; dropped > 0 {
+		logrus.Warnf("Offline queue is full (max_size=%d), dropping %d oldest writes", q.MaxSize, dropped)
+		q.entries = append([]QueueEntry{}, q.entries[dropped:]...)
+	}
+}
+
+// supersedeStatus removes the queued status updates of a component
+func supersedeStatus(entries []QueueEntry, componentID int) []QueueEntry {
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.Type == QueueEntryComponentStatus && entry.ID == componentID {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+
+	return kept
+}
+
+// append writes an entry to the log and syncs it to disk
+func (q *OfflineQueue) append(entry QueueEntry) error {
+	// rewrite the log once it is mostly made of delivered or superseded entries
+	if q.fileEntries >= 2*q.MaxSize {
+		return q.compact()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := q.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	q.fileEntries++
+
+	return q.file.Sync()
+}
+
+// compact atomically replaces the log with the entries still queued
+func (q *OfflineQueue) compact() error {
+	tmp := q.path() + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, entry := range q.entries {
+		data, _ := json.Marshal(entry)
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	if err := os.Rename(tmp, q.path()); err != nil {
+		return err
+	}
+
+	if q.file != nil {
+		q.file.Close()
+	}
+	q.file, err = os.OpenFile(q.path(), os.O_APPEND|os.O_WRONLY, 0600)
+	q.fileEntries = len(q.entries)
+
+	return err
+}
+
+// readQueueFile returns the entries of a queue log, ignoring a truncated last line
+func readQueueFile(path string) ([]QueueEntry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []QueueEntry{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry QueueEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logrus.Warnf("Offline queue: skipping corrupted entry in %s: %v", path, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+func (q *OfflineQueue) run(ctx context.Context) {
+	defer close(q.done)
+
+	ticker := time.NewTicker(queueReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		q.replay(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// replay sends the queued writes in order if Cachet is reachable, stopping at the first transient failure
+func (q *OfflineQueue) replay(ctx context.Context) {
+	q.replaying.Lock()
+	defer q.replaying.Unlock()
+
+	if q.Len() == 0 {
+		return
+	}
+
+	if err := q.api.Ping(ctx); err != nil {
+		logrus.Debugf("Offline queue: Cachet still unreachable (%d writes queued): %v", q.Len(), err)
+		return
+	}
+
+	logrus.Infof("Offline queue: Cachet is reachable, replaying %d writes", q.Len())
+
+	sent := 0
+	for {
+		q.mu.Lock()
+		if len(q.entries) == 0 {
+			q.mu.Unlock()
+			break
+		}
+		entry := q.entries[0]
+		q.mu.Unlock()
+
+		err := q.send(ctx, entry)
+		if err != nil && isTransientError(err) {
+			logrus.Warnf("Offline queue: replay interrupted (%d writes sent): %v", sent, err)
+			break
+		} else if err != nil {
+			logrus.Warnf("Offline queue: dropping %s write for ID %d: %v", entry.Type, entry.ID, err)
+		} else {
+			sent++
+		}
+
+		q.mu.Lock()
+		// the entry may have been superseded or dropped meanwhile
+		if len(q.entries) > 0 && q.entries[0] == entry {
+			q.entries = q.entries[1:]
+		}
+		q.mu.Unlock()
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.compact(); err != nil {
+		logrus.Warnf("Offline queue: cannot rewrite %s: %v", q.path(), err)
+	}
+	if sent > 0 {
+		logrus.Infof("Offline queue: replayed %d writes, %d remaining", sent, len(q.entries))
+	}
+}
+
+func (q *OfflineQueue) send(ctx context.Context, entry QueueEntry) error {
+	switch entry.Type {
+	case QueueEntryMetric:
+		return q.api.SendMetricPoint(ctx, entry.ID, entry.Value, entry.Timestamp)
+	case QueueEntryComponentStatus:
+		_, err := q.api.UpdateComponentStatus(ctx, entry.ID, entry.Status)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package cachet
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOfflineQueueCoalesce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachet-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := &OfflineQueue{Directory: dir, MaxSize: 3}
+	if err := q.Open(&CachetAPI{}); err != nil {
+		t.Fatal(err)
+	}
+	q.Push(QueueEntry{Type: QueueEntryComponentStatus, ID: 1, Status: 4, Timestamp: 1})
+	q.Push(QueueEntry{Type: QueueEntryMetric, ID: 2, Value: 10, Timestamp: 2})
+	q.Push(QueueEntry{Type: QueueEntryComponentStatus, ID: 1, Status: 1, Timestamp: 3})
+	if q.Len() != 2 {
+		t.Errorf("superseded status should be coalesced, got %d entries", q.Len())
+	}
+
+	q.Push(QueueEntry{Type: QueueEntryMetric, ID: 2, Value: 20, Timestamp: 4})
+	q.Push(QueueEntry{Type: QueueEntryMetric, ID: 2, Value: 30, Timestamp: 5})
+	if q.Len() != 3 || q.entries[0].Timestamp != 3 {
+		t.Errorf("oldest entries should be dropped above max_size, got %v", q.entries)
+	}
+	q.Close()
+
+	// entries survive a restart
+	q = &OfflineQueue{Directory: dir, MaxSize: 3}
+	if err := q.Open(&CachetAPI{}); err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 3 || q.entries[2].Value != 30 {
+		t.Errorf("expected queued entries to be reloaded, got %v", q.entries)
+	}
+
+	q.Delivered(1)
+	if q.Len() != 2 {
+		t.Errorf("delivered status should be removed from the queue, got %d entries", q.Len())
+	}
+	q.Close()
+
+	// the superseded status is not replayed after a restart
+	q = &OfflineQueue{Directory: dir, MaxSize: 3}
+	if err := q.Open(&CachetAPI{}); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 2 || q.entries[0].Type != QueueEntryMetric {
+		t.Errorf("expected the delivered status to stay removed, got %v", q.entries)
+	}
+}
+
+func TestOfflineQueueReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachet-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var mu sync.Mutex
+	requests := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/ping" {
+			requests = append(requests, r.Method+" "+r.URL.Path+" "+string(body))
+		}
+		if r.URL.Path == "/metrics/404/points" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data":{}}`))
+	}))
+	defer srv.Close()
+
+	q := &OfflineQueue{Directory: dir, MaxSize: 10}
+	if err := q.Open(&CachetAPI{URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	q.Push(QueueEntry{Type: QueueEntryMetric, ID: 1, Value: 5, Timestamp: 100})
+	q.Push(QueueEntry{Type: QueueEntryMetric, ID: 404, Value: 5, Timestamp: 101})
+	q.Push(QueueEntry{Type: QueueEntryComponentStatus, ID: 2, Status: 4, Timestamp: 102})
+	q.replay(context.Background())
+
+	if q.Len() != 0 {
+		t.Errorf("queue should be empty after replay, %d entries left", q.Len())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(requests) != 3 {
+		t.Fatalf("expected 3 replayed requests, got %v", requests)
+	}
+	if !strings.HasPrefix(requests[0], "POST /metrics/1/points") || !strings.Contains(requests[0], `"timestamp":100`) {
+		t.Errorf("metric point should be replayed with its original timestamp, got %q", requests[0])
+	}
+	if !strings.HasPrefix(requests[2], "PUT /components/2") {
+		t.Errorf("component status should be replayed last, got %q", requests[2])
+	}
+}
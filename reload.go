@@ -0,0 +1,155 @@
+package cachet
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// monitor settings which do not affect the check itself,
+// changing them restarts the monitor without losing its history
+var reloadKeepStateKeys = map[string]bool{
+	"template":   true,
+	"on_success": true,
+	"on_failure": true,
+	"metric_id":  true,
+	"metrics":    true,
+}
+
+// monitorKey identifies a monitor across configurations
+func monitorKey(mon *AbstractMonitor) string {
+	return mon.Name + "/" + strconv.Itoa(mon.ComponentID)
+}
+
+// checkSettings returns the raw monitor configuration without the reloadKeepStateKeys
+func checkSettings(raw map[string]interface{}) map[string]interface{} {
+	settings := map[string]interface{}{}
+	for key, value := range raw {
+		if !reloadKeepStateKeys[strings.ToLower(key)] {
+			settings[key] = value
+		}
+	}
+
+	return settings
+}
+
+// RestartRequired lists the global settings of newCfg which cannot be changed without a restart
+func (cfg *CachetMonitor) RestartRequired(newCfg *CachetMonitor) []string {
+	changed := []string{}
+
+	if cfg.API.URL != newCfg.API.URL || cfg.API.Token != newCfg.API.Token || cfg.API.Insecure != newCfg.API.Insecure ||
+		cfg.API.Timeout != newCfg.API.Timeout || cfg.API.Retries != newCfg.API.Retries {
+		changed = append(changed, "api")
+	}
+	if cfg.DryRun != newCfg.DryRun {
+		changed = append(changed, "dry_run")
+	}
+	if cfg.SystemName != newCfg.SystemName {
+		changed = append(changed, "system_name")
+	}
+	if cfg.DateFormat != newCfg.DateFormat {
+		changed = append(changed, "date_format")
+	}
+	if len(newCfg.Heartbeat.Listen) > 0 && cfg.Heartbeat.Listen != newCfg.Heartbeat.Listen {
+		changed = append(changed, "heartbeat")
+	}
+	if cfg.Queue.Directory != newCfg.Queue.Directory {
+		changed = append(changed, "queue")
+	}
+	if cfg.State.File != newCfg.State.File {
+		changed = append(changed, "state")
+	}
+	if cfg.Peers.Listen != newCfg.Peers.Listen || cfg.Peers.Location != newCfg.Peers.Location || cfg.Peers.Secret != newCfg.Peers.Secret ||
+		cfg.Peers.Quorum != newCfg.Peers.Quorum || cfg.Peers.PollInterval != newCfg.Peers.PollInterval || !reflect.DeepEqual(cfg.Peers.Nodes, newCfg.Peers.Nodes) {
+		changed = append(changed, "peers")
+	}
+
+	return changed
+}
+
+// ReloadMonitors replaces the running monitors with the ones of newCfg, which must have been validated.
+// Monitors are matched by name and component ID: unchanged ones keep running, removed ones are stopped,
+// new ones are started and changed ones are restarted. A restarted monitor keeps its history
+// unless a setting of the check itself changed.
+func (cfg *CachetMonitor) ReloadMonitors(newCfg *CachetMonitor, wg *sync.WaitGroup) {
+	running := map[string]int{}
+	for index, monitor := range cfg.Monitors {
+		running[monitorKey(monitor.GetMonitor())] = index
+	}
+
+	monitors := make([]MonitorInterface, len(newCfg.Monitors))
+	rawMonitors := make([]map[string]interface{}, len(newCfg.Monitors))
+	states := map[int]*MonitorState{}
+	stopped := map[int]bool{}
+
+	for index, monitor := range newCfg.Monitors {
+		rawMonitors[index] = newCfg.RawMonitors[index]
+
+		oldIndex, ok := running[monitorKey(monitor.GetMonitor())]
+		if !ok || stopped[oldIndex] {
+			continue
+		}
+		stopped[oldIndex] = true
+
+		oldMonitor := cfg.Monitors[oldIndex]
+		oldRaw := cfg.RawMonitors[oldIndex]
+		newRaw := newCfg.RawMonitors[index]
+
+		// monitors skipped on a previous start are given another chance
+		if reflect.DeepEqual(oldRaw, newRaw) && oldMonitor.GetMonitor().stopC != nil {
+			monitors[index] = oldMonitor
+			continue
+		}
+
+		oldMonitor.ClockStop()
+		oldMonitor.GetMonitor().clockWait()
+
+		if reflect.DeepEqual(checkSettings(oldRaw), checkSettings(newRaw)) {
+			logrus.Infof("Restarting monitor %s (history kept)", oldMonitor.GetMonitor().Name)
+			states[index] = oldMonitor.GetMonitor().snapshot()
+		} else {
+			logrus.Infof("Restarting monitor %s (check changed, history reset)", oldMonitor.GetMonitor().Name)
+			// discard the state saved by the previous instance
+			states[index] = &MonitorState{}
+		}
+	}
+
+	for index, monitor := range cfg.Monitors {
+		if !stopped[index] {
+			logrus.Infof("Stopping removed monitor %s", monitor.GetMonitor().Name)
+			monitor.ClockStop()
+			monitor.GetMonitor().clockWait()
+		}
+	}
+
+	for index, monitor := range newCfg.Monitors {
+		if monitors[index] != nil {
+			continue
+		}
+		monitors[index] = monitor
+
+		mon := monitor.GetMonitor()
+		logrus.Infof("Starting monitor %s", mon.Name)
+		logrus.Infof("Features: \n - %v", strings.Join(monitor.Describe(), "\n - "))
+
+		if !monitor.Init(cfg) {
+			logrus.Errorf("Monitor %s has been skipped", mon.Name)
+			continue
+		}
+		if state, ok := states[index]; ok {
+			mon.restore(state)
+			if len(mon.history) == 0 {
+				mon.pushHistory(mon.isUp(), mon.isPartial())
+			}
+		}
+
+		monitor.ClockStart(cfg, monitor, wg)
+	}
+
+	cfg.Monitors = monitors
+	cfg.RawMonitors = rawMonitors
+	cfg.Admin.setMonitors(monitors)
+}
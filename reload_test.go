@@ -0,0 +1,99 @@
+package cachet
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newReloadConfig(url string, raws ...map[string]interface{}) *CachetMonitor {
+	cfg := &CachetMonitor{API: CachetAPI{URL: url, Token: "token"}, RawMonitors: raws}
+	for _, raw := range raws {
+		mon := &MockMonitor{AbstractMonitor: AbstractMonitor{
+			Name:        raw["name"].(string),
+			ComponentID: raw["component_id"].(int),
+			Interval:    time.Duration(raw["interval"].(int)),
+			Timeout:     1,
+		}}
+		mon.Validate()
+		cfg.Monitors = append(cfg.Monitors, mon)
+	}
+
+	return cfg
+}
+
+// waitStarted waits for each monitor goroutine to run a no-op command
+func waitStarted(t *testing.T, cfg *CachetMonitor) {
+	for _, monitor := range cfg.Monitors {
+		if err := monitor.GetMonitor().run(monitor, func() {}); err != nil {
+			t.Fatalf("monitor %s did not start: %v", monitor.GetMonitor().Name, err)
+		}
+	}
+}
+
+func TestReloadMonitors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/components/") {
+			id := strings.TrimPrefix(r.URL.Path, "/components/")
+			w.Write([]byte(`{"data":{"id":` + id + `,"status":1,"enabled":true}}`))
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	}))
+	defer srv.Close()
+
+	raw := func(name string, id int, interval int, template string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "component_id": id, "interval": interval, "template": template}
+	}
+
+	wg := &sync.WaitGroup{}
+	cfg := &CachetMonitor{API: CachetAPI{URL: srv.URL, Token: "token"}}
+	cfg.ReloadMonitors(newReloadConfig(srv.URL,
+		raw("unchanged", 1, 3600, "a"),
+		raw("template", 2, 3600, "a"),
+		raw("check", 3, 3600, "a"),
+		raw("removed", 4, 3600, "a"),
+	), wg)
+	waitStarted(t, cfg)
+
+	old := make([]*AbstractMonitor, len(cfg.Monitors))
+	for i, monitor := range cfg.Monitors {
+		old[i] = monitor.GetMonitor()
+		old[i].history = []bool{false, false, true}
+		old[i].warningHistory = []bool{false, false, false}
+	}
+
+	cfg.ReloadMonitors(newReloadConfig(srv.URL,
+		raw("unchanged", 1, 3600, "a"),
+		raw("template", 2, 3600, "b"),
+		raw("check", 3, 1800, "a"),
+		raw("added", 5, 3600, "a"),
+	), wg)
+	waitStarted(t, cfg)
+
+	if cfg.Monitors[0].GetMonitor() != old[0] {
+		t.Error("unchanged monitor should keep running")
+	}
+	if mon := cfg.Monitors[1].GetMonitor(); mon == old[1] || len(mon.history) != 3 {
+		t.Errorf("monitor with a new template should be restarted with its history, got %v", mon.history)
+	}
+	if mon := cfg.Monitors[2].GetMonitor(); mon == old[2] || len(mon.history) != 1 {
+		t.Errorf("monitor with a new check should be restarted without history, got %v", mon.history)
+	}
+	if cfg.Monitors[3].GetMonitor().Name != "added" {
+		t.Error("new monitor should be started")
+	}
+	select {
+	case <-old[3].doneC:
+	default:
+		t.Error("removed monitor should be stopped")
+	}
+
+	for _, monitor := range cfg.Monitors {
+		monitor.ClockStop()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,415 @@
+package cachet
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// the simulated Cachet API is served in memory, this host is never resolved
+const simulationURL = "http://cachet.simulation"
+
+// Cachet component statuses
+var componentStatusNames = map[int]string{
+	1: "operational",
+	2: "performance issues",
+	3: "partial outage",
+	4: "major outage",
+}
+
+// Cachet incident statuses
+var incidentStatusNames = map[int]string{
+	1: "investigating",
+	2: "identified",
+	3: "watching",
+	4: "fixed",
+}
+
+var simulationIncidentPath = regexp.MustCompile(`^/incidents/(\d+)(/updates)?$`)
+
+// SimulatedCheck is a recorded check result replayed by a simulation
+type SimulatedCheck struct {
+	Time      time.Time
+	Up        bool
+	Warning   bool
+	LatencyMs int64
+	// defaults to "Simulated failure"
+	FailReason string
+}
+
+// SimulationEvent is a component status change or incident write made during a simulation
+type SimulationEvent struct {
+	Time time.Time `json:"time"`
+	// component_status, incident_created, incident_updated or incident_update
+	Type            string `json:"type"`
+	ComponentStatus int    `json:"component_status,omitempty"`
+	IncidentID      int    `json:"incident_id,omitempty"`
+	IncidentStatus  int    `json:"incident_status,omitempty"`
+	Name            string `json:"name,omitempty"`
+}
+
+func (event SimulationEvent) String() string {
+	switch event.Type {
+	case "component_status":
+		return "component => " + componentStatusNames[event.ComponentStatus]
+	case "incident_created":
+		return fmt.Sprintf("incident #%d created: '%s' (%s)", event.IncidentID, event.Name, incidentStatusNames[event.IncidentStatus])
+	case "incident_updated":
+		return fmt.Sprintf("incident #%d %s", event.IncidentID, incidentStatusNames[event.IncidentStatus])
+	}
+
+	return fmt.Sprintf("incident #%d update posted", event.IncidentID)
+}
+
+// Simulation is the timeline a monitor configuration produced from recorded check results
+type Simulation struct {
+	Monitor   string `json:"monitor"`
+	Checks    int    `json:"checks"`
+	Failures  int    `json:"failures"`
+	Incidents int    `json:"incidents"`
+	// time the component spent in another status than operational
+	NotOperationalSeconds int64             `json:"not_operational_seconds"`
+	Events                []SimulationEvent `json:"events"`
+}
+
+// simulatedCachet is an in-memory Cachet API holding a single component
+type simulatedCachet struct {
+	mu              sync.Mutex
+	clock           func() time.Time
+	componentID     int
+	componentStatus int
+	incidents       int
+	events          []SimulationEvent
+}
+
+func (fake *simulatedCachet) setComponentStatus(status int) {
+	if status == 0 || status == fake.componentStatus {
+		return
+	}
+	fake.componentStatus = status
+	fake.events = append(fake.events, SimulationEvent{Time: fake.clock(), Type: "component_status", ComponentStatus: status})
+}
+
+func (fake *simulatedCachet) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	var incident Incident
+	var component struct {
+		Status int `json:"status"`
+	}
+	data := map[string]interface{}{}
+
+	switch match := simulationIncidentPath.FindStringSubmatch(r.URL.Path); {
+	case r.URL.Path == "/components/"+strconv.Itoa(fake.componentID):
+		if r.Method == "PUT" {
+			json.NewDecoder(r.Body).Decode(&component)
+			fake.setComponentStatus(component.Status)
+		}
+		data = map[string]interface{}{"id": fake.componentID, "status": fake.componentStatus, "enabled": true}
+	case r.Method == "POST" && r.URL.Path == "/incidents":
+		json.NewDecoder(r.Body).Decode(&incident)
+		fake.incidents++
+		fake.events = append(fake.events, SimulationEvent{Time: fake.clock(), Type: "incident_created", IncidentID: fake.incidents, IncidentStatus: incident.Status, Name: incident.Name})
+		fake.setComponentStatus(incident.ComponentStatus)
+		data["id"] = fake.incidents
+	case r.Method == "PUT" && match != nil && len(match[2]) == 0:
+		json.NewDecoder(r.Body).Decode(&incident)
+		id, _ := strconv.Atoi(match[1])
+		fake.events = append(fake.events, SimulationEvent{Time: fake.clock(), Type: "incident_updated", IncidentID: id, IncidentStatus: incident.Status, Name: incident.Name})
+		fake.setComponentStatus(incident.ComponentStatus)
+		data["id"] = id
+	case r.Method == "POST" && match != nil:
+		id, _ := strconv.Atoi(match[1])
+		fake.events = append(fake.events, SimulationEvent{Time: fake.clock(), Type: "incident_update", IncidentID: id})
+	case r.Method == "GET":
+		// incident and schedule lists
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": []interface{}{}})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// handlerTransport serves the requests with a handler, without a network
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (transport handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	transport.handler.ServeHTTP(rec, req)
+
+	return rec.Result(), nil
+}
+
+// parseSimulatedStatus reads up/down/warning (or ok/fail, true/false, 1/0)
+func parseSimulatedStatus(status string) (bool, bool, error) {
+	switch strings.ToLower(strings.TrimSpace(status)) {
+	case "up", "ok", "true", "1":
+		return true, false, nil
+	case "down", "fail", "failure", "false", "0":
+		return false, false, nil
+	case "warning", "warn":
+		return false, true, nil
+	}
+
+	return false, false, errors.New("unknown status '" + status + "' (up, down or warning)")
+}
+
+// parseSimulatedTime reads an RFC 3339 date or a unix timestamp
+func parseSimulatedTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Unix(0, int64(seconds*float64(time.Second))), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// ReadSimulatedChecks reads check results as CSV (timestamp,status,latency_ms[,fail_reason] with an optional header)
+// or as JSON lines ({"timestamp": ..., "up": true, "latency": 120}), sorted by time
+func ReadSimulatedChecks(r io.Reader) ([]SimulatedCheck, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var checks []SimulatedCheck
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		checks, err = readSimulatedJSONLines(data)
+	} else {
+		checks, err = readSimulatedCSV(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(checks, func(i, j int) bool { return checks[i].Time.Before(checks[j].Time) })
+
+	return checks, nil
+}
+
+func readSimulatedCSV(data []byte) ([]SimulatedCheck, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []SimulatedCheck{}
+	for i, record := range records {
+		if i == 0 && strings.ToLower(strings.TrimSpace(record[0])) == "timestamp" {
+			continue
+		}
+		if len(record) < 2 {
+			return nil, fmt.Errorf("record %d: expected timestamp,status[,latency_ms[,fail_reason]]", i+1)
+		}
+
+		var check SimulatedCheck
+		if check.Time, err = parseSimulatedTime(record[0]); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if check.Up, check.Warning, err = parseSimulatedStatus(record[1]); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if len(record) > 2 && len(strings.TrimSpace(record[2])) > 0 {
+			latency, err := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid latency: %v", i+1, err)
+			}
+			check.LatencyMs = int64(latency)
+		}
+		if len(record) > 3 {
+			check.FailReason = record[3]
+		}
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+func readSimulatedJSONLines(data []byte) ([]SimulatedCheck, error) {
+	checks := []SimulatedCheck{}
+	for i, line := range strings.Split(string(data), "\n") {
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record struct {
+			// unix timestamp or RFC 3339 date
+			Timestamp  interface{} `json:"timestamp"`
+			Time       string      `json:"time"`
+			Up         *bool       `json:"up"`
+			Status     string      `json:"status"`
+			Warning    bool        `json:"warning"`
+			Latency    float64     `json:"latency"`
+			FailReason string      `json:"fail_reason"`
+		}
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.UseNumber()
+		if err := decoder.Decode(&record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+
+		var check SimulatedCheck
+		var err error
+		timestamp := record.Time
+		switch value := record.Timestamp.(type) {
+		case json.Number:
+			timestamp = value.String()
+		case string:
+			timestamp = value
+		}
+		if check.Time, err = parseSimulatedTime(timestamp); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if record.Up != nil {
+			check.Up = *record.Up
+			check.Warning = !check.Up && record.Warning
+		} else if check.Up, check.Warning, err = parseSimulatedStatus(record.Status); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		check.LatencyMs = int64(record.Latency)
+		check.FailReason = record.FailReason
+		checks = append(checks, check)
+	}
+
+	return checks, nil
+}
+
+// simulationCopy returns a monitor with the settings (exported fields) of mon and a blank state,
+// so that a simulation leaves the configured monitor untouched
+func simulationCopy(mon *AbstractMonitor) *AbstractMonitor {
+	copied := &AbstractMonitor{}
+	src := reflect.ValueOf(mon).Elem()
+	dst := reflect.ValueOf(copied).Elem()
+	for i := 0; i < src.NumField(); i++ {
+		if len(src.Type().Field(i).PkgPath) == 0 {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+	// the windows keep their parsed schedule
+	copied.Maintenance = append([]MaintenanceWindow{}, mon.Maintenance...)
+
+	return copied
+}
+
+// Simulate replays check results through the analysis run after each test (see recordCheck) against
+// an in-memory Cachet, using the time of each check as the current time. name selects the monitor
+// (optional with a single monitor). The component starts operational, without incident, and the monitor's
+// dependencies and metrics are ignored. The simulation runs on a copy of the monitor.
+func (cfg *CachetMonitor) Simulate(name string, checks []SimulatedCheck) (*Simulation, error) {
+	var monitor MonitorInterface
+	for _, m := range cfg.Monitors {
+		if m.GetMonitor().Name == name || (len(name) == 0 && len(cfg.Monitors) == 1) {
+			monitor = m
+		}
+	}
+	if monitor == nil {
+		if len(name) == 0 {
+			return nil, errors.New("the configuration has several monitors, select one")
+		}
+		return nil, errors.New("Unknown monitor: " + name)
+	}
+
+	if errs := monitor.Validate(); len(errs) > 0 {
+		return nil, errors.New("Monitor validation errors:\n - " + strings.Join(errs, "\n - "))
+	}
+
+	var current time.Time
+	if len(checks) > 0 {
+		current = checks[0].Time
+	}
+	clock := func() time.Time { return current }
+
+	sim := &CachetMonitor{SystemName: cfg.SystemName, DateFormat: cfg.DateFormat, clock: clock}
+	if len(sim.SystemName) == 0 {
+		sim.SystemName = getHostname()
+	}
+	if len(sim.DateFormat) == 0 {
+		sim.DateFormat = DefaultTimeFormat
+	}
+
+	mon := simulationCopy(monitor.GetMonitor())
+	if mon.ComponentID == 0 {
+		mon.ComponentID = 1
+	}
+	fake := &simulatedCachet{clock: clock, componentID: mon.ComponentID, componentStatus: 1}
+	sim.API = CachetAPI{URL: simulationURL, Token: "simulation", Retries: -1}
+	sim.API.clientOnce.Do(func() {
+		sim.API.client = &http.Client{Transport: handlerTransport{handler: fake}}
+	})
+
+	mon.config = sim
+	// the timeline is about this monitor only
+	mon.DependsOn = nil
+	mon.Metrics.Availability = nil
+	mon.Metrics.IncidentCount = nil
+	// status, enabled state and incident of the simulated component
+	if err := mon.ReloadCachetData(); err != nil {
+		return nil, err
+	}
+
+	l := logrus.WithFields(logrus.Fields{"monitor": mon.Name, "simulation": true})
+
+	simulation := &Simulation{Monitor: mon.Name, Checks: len(checks)}
+	var notOperationalSince time.Time
+	for _, check := range checks {
+		current = check.Time
+		if !mon.Enabled {
+			// skipped by tick as well
+			continue
+		}
+		mon.lastTick = check.Time
+
+		if !check.Up {
+			simulation.Failures++
+			mon.lastFailReason = check.FailReason
+			if len(mon.lastFailReason) == 0 {
+				mon.lastFailReason = "Simulated failure"
+			}
+		}
+		mon.warning = check.Warning
+		mon.noLag = false
+
+		mon.recordCheck(l, check.Up, check.LatencyMs)
+
+		fake.mu.Lock()
+		status := fake.componentStatus
+		fake.mu.Unlock()
+		if status != 1 && notOperationalSince.IsZero() {
+			notOperationalSince = current
+		} else if status == 1 && !notOperationalSince.IsZero() {
+			simulation.NotOperationalSeconds += int64(current.Sub(notOperationalSince) / time.Second)
+			notOperationalSince = time.Time{}
+		}
+	}
+	if !notOperationalSince.IsZero() {
+		simulation.NotOperationalSeconds += int64(current.Sub(notOperationalSince) / time.Second)
+	}
+
+	simulation.Incidents = fake.incidents
+	simulation.Events = append([]SimulationEvent{}, fake.events...)
+
+	return simulation, nil
+}
@@ -0,0 +1,105 @@
+package cachet
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadSimulatedChecks(t *testing.T) {
+	csvInput := `timestamp,status,latency_ms,fail_reason
+2026-01-01T00:01:00Z,down,,"timeout, after 10s"
+# checks may be out of order
+1767225600,up,120
+2026-01-01T00:02:00Z,warning,30
+`
+	checks, err := ReadSimulatedChecks(strings.NewReader(csvInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 3 {
+		t.Fatalf("expected 3 checks, got %v", checks)
+	}
+	if !checks[0].Up || checks[0].LatencyMs != 120 || !checks[0].Time.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the checks to be sorted by time, got %+v", checks[0])
+	}
+	if checks[1].Up || checks[1].FailReason != "timeout, after 10s" || !checks[2].Warning {
+		t.Errorf("unexpected checks: %+v", checks)
+	}
+
+	jsonInput := `{"timestamp": 1767225600, "up": true, "latency": 80}
+
+{"time": "2026-01-01T00:01:00Z", "status": "down", "fail_reason": "refused"}
+{"timestamp": "2026-01-01T00:02:00Z", "up": true}
+`
+	checks, err = ReadSimulatedChecks(strings.NewReader(jsonInput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 3 || !checks[0].Up || checks[0].LatencyMs != 80 || checks[1].Up || checks[1].FailReason != "refused" {
+		t.Errorf("unexpected checks: %+v", checks)
+	}
+	if !checks[2].Up || !checks[2].Time.Equal(time.Date(2026, 1, 1, 0, 2, 0, 0, time.UTC)) {
+		t.Errorf("unexpected checks: %+v", checks)
+	}
+
+	for _, input := range []string{"2026-01-01T00:00:00Z,maybe", "yesterday,up", `{"timestamp": 1, "status": "sideways"}`} {
+		if _, err := ReadSimulatedChecks(strings.NewReader(input)); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	web := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "web", ComponentID: 7, ThresholdCount: 2, HistorySize: 3}}
+	api := &MockMonitor{AbstractMonitor: AbstractMonitor{Name: "api", ComponentID: 8}}
+	cfg := &CachetMonitor{Monitors: []MonitorInterface{web, api}}
+
+	if _, err := cfg.Simulate("", nil); err == nil {
+		t.Error("expected an error without a monitor name")
+	}
+	if _, err := cfg.Simulate("db", nil); err == nil {
+		t.Error("expected an unknown monitor error")
+	}
+
+	simulation, err := cfg.Simulate("web", simulatedChecks("uudddduuu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if simulation.Checks != 9 || simulation.Failures != 4 || simulation.Incidents != 1 {
+		t.Errorf("unexpected simulation: %+v", simulation)
+	}
+	// down from the 4th check (3 minutes) until resolved on the 8th (7 minutes)
+	if simulation.NotOperationalSeconds != 240 {
+		t.Errorf("expected 4 minutes not operational, got %ds", simulation.NotOperationalSeconds)
+	}
+	if start := simulatedChecks("u")[0].Time; !simulation.Events[0].Time.Equal(start.Add(3 * time.Minute)) {
+		t.Errorf("expected the incident at the simulated time, got %v", simulation.Events[0].Time)
+	}
+}
+
+func TestSimulateMaintenance(t *testing.T) {
+	web := &MockMonitor{AbstractMonitor: AbstractMonitor{
+		Name:           "web",
+		ComponentID:    7,
+		ThresholdCount: 2,
+		HistorySize:    3,
+		DependsOn:      []string{"db"},
+		// minutes 2 to 5 of the simulation
+		Maintenance: []MaintenanceWindow{{Cron: "2 0 1 1 *", Duration: 240}},
+	}}
+	web.Metrics.Availability = []int{3}
+	cfg := &CachetMonitor{Monitors: []MonitorInterface{web}}
+
+	simulation, err := cfg.Simulate("web", simulatedChecks("uudddduuu"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if simulation.Failures != 4 || len(simulation.Events) > 0 {
+		t.Errorf("expected no incident during the maintenance window, got %v", simulation.Events)
+	}
+
+	if web.config != nil || len(web.history) > 0 || len(web.DependsOn) != 1 || len(web.Metrics.Availability) != 1 {
+		t.Errorf("expected the configured monitor to be left untouched, got config=%v history=%v", web.config, web.history)
+	}
+}
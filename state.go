@@ -0,0 +1,254 @@
+package cachet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const DefaultStateSaveEvery = 60
+
+// StateStore persists monitor state across restarts
+type StateStore interface {
+	// Load returns nil when no state has been saved for this key
+	Load(key string) (*MonitorState, error)
+	Save(key string, state *MonitorState) error
+}
+
+// StateConfig enables the default JSON state store
+type StateConfig struct {
+	// disabled when empty
+	File string `json:"file" yaml:"file"`
+	// seconds between snapshots of each monitor
+	SaveEvery time.Duration `json:"save_every" yaml:"save_every"`
+}
+
+// MonitorState is the part of a monitor's runtime state worth restoring after a restart
+type MonitorState struct {
+	SavedAt time.Time `json:"saved_at"`
+
+	History          []bool   `json:"history"`
+	WarningHistory   []bool   `json:"warning_history"`
+	CurrentUpCount   int      `json:"current_up_count"`
+	CurrentDownCount int      `json:"current_down_count"`
+	LastFailReason   string   `json:"last_fail_reason"`
+	FailReasons      []string `json:"fail_reasons"`
+	ResyncMod        int      `json:"resync_mod"`
+
+	LagHistory          []int64 `json:"lag_history"`
+	LagBaseline         float32 `json:"lag_baseline"`
+	PerformanceDegraded bool    `json:"performance_degraded"`
+	Flapping            bool    `json:"flapping"`
+	StateChange         float32 `json:"state_change"`
+
+	// incident timings are only restored if the same incident is still open
+	IncidentID            int       `json:"incident_id"`
+	IncidentIsPerformance bool      `json:"incident_is_performance"`
+	IncidentStart         time.Time `json:"incident_start"`
+	WatchingSince         time.Time `json:"watching_since"`
+	LastIncidentUpdate    time.Time `json:"last_incident_update"`
+}
+
+// JSONStateStore keeps the state of every monitor in a single JSON file
+type JSONStateStore struct {
+	Path string
+
+	mu     sync.Mutex
+	states map[string]*MonitorState
+}
+
+func (store *JSONStateStore) load() error {
+	if store.states != nil {
+		return nil
+	}
+
+	states := map[string]*MonitorState{}
+	data, err := ioutil.ReadFile(store.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err == nil {
+		if err := json.Unmarshal(data, &states); err != nil {
+			return err
+		}
+	}
+	store.states = states
+
+	return nil
+}
+
+func (store *JSONStateStore) Load(key string) (*MonitorState, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store.states[key], nil
+}
+
+// Save writes the whole file atomically
+func (store *JSONStateStore) Save(key string, state *MonitorState) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if err := store.load(); err != nil {
+		return err
+	}
+	store.states[key] = state
+
+	data, err := json.MarshalIndent(store.states, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(store.Path), filepath.Base(store.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), store.Path)
+}
+
+// stateKey identifies a monitor in the state store
+func (mon *AbstractMonitor) stateKey() string {
+	return mon.Name + "/" + strconv.Itoa(mon.ComponentID)
+}
+
+// snapshot returns a copy of the monitor state
+func (mon *AbstractMonitor) snapshot() *MonitorState {
+	state := &MonitorState{
+		SavedAt:             time.Now(),
+		History:             append([]bool{}, mon.history...),
+		WarningHistory:      append([]bool{}, mon.warningHistory...),
+		CurrentUpCount:      mon.currentUpCount,
+		CurrentDownCount:    mon.currentDownCount,
+		LastFailReason:      mon.lastFailReason,
+		FailReasons:         append([]string{}, mon.failReasons...),
+		ResyncMod:           mon.resyncMod,
+		LagHistory:          append([]int64{}, mon.lagHistory...),
+		LagBaseline:         mon.lagBaseline,
+		PerformanceDegraded: mon.performanceDegraded,
+		Flapping:            mon.flapping,
+		StateChange:         mon.stateChange,
+	}
+
+	if mon.incident != nil {
+		state.IncidentID = mon.incident.ID
+		state.IncidentIsPerformance = mon.incidentIsPerformance
+		state.IncidentStart = mon.incidentStart
+		state.WatchingSince = mon.watchingSince
+		state.LastIncidentUpdate = mon.lastIncidentUpdate
+	}
+
+	return state
+}
+
+// restore loads a snapshot, the current status and incident are expected to be reloaded from Cachet beforehand
+func (mon *AbstractMonitor) restore(state *MonitorState) {
+	history := state.History
+	warningHistory := state.WarningHistory
+	if len(warningHistory) != len(history) {
+		warningHistory = make([]bool, len(history))
+	}
+	if len(history) > mon.HistorySize {
+		history = history[len(history)-mon.HistorySize:]
+		warningHistory = warningHistory[len(warningHistory)-mon.HistorySize:]
+	}
+	mon.history = append([]bool{}, history...)
+	mon.warningHistory = append([]bool{}, warningHistory...)
+
+	mon.currentUpCount = state.CurrentUpCount
+	mon.currentDownCount = state.CurrentDownCount
+	mon.lastFailReason = state.LastFailReason
+	mon.failReasons = append([]string{}, state.FailReasons...)
+	if mon.Resync > 0 {
+		mon.resyncMod = state.ResyncMod % mon.Resync
+	}
+
+	if mon.performanceEnabled() {
+		lagHistory := state.LagHistory
+		if len(lagHistory) > mon.PerformanceWindow {
+			lagHistory = lagHistory[len(lagHistory)-mon.PerformanceWindow:]
+		}
+		mon.lagHistory = append([]int64{}, lagHistory...)
+		mon.lagBaseline = state.LagBaseline
+		mon.performanceDegraded = state.PerformanceDegraded
+	}
+	if mon.FlapDetection {
+		mon.flapping = state.Flapping
+		mon.stateChange = state.StateChange
+	}
+
+	if mon.incident != nil && mon.incident.ID == state.IncidentID {
+		mon.incidentIsPerformance = state.IncidentIsPerformance
+		mon.incidentStart = state.IncidentStart
+		mon.watchingSince = state.WatchingSince
+		mon.lastIncidentUpdate = state.LastIncidentUpdate
+	}
+}
+
+// loadState restores the saved state, returns false if there is none or if it is too old to be relevant
+func (mon *AbstractMonitor) loadState() bool {
+	store := mon.config.StateStore
+	if store == nil {
+		return false
+	}
+
+	state, err := store.Load(mon.stateKey())
+	if err != nil {
+		logrus.Warnf("Could not load state of monitor %s: %v", mon.Name, err)
+		return false
+	}
+	if state == nil {
+		return false
+	}
+
+	// the history would no longer describe the current state of the service
+	if maxAge := time.Duration(mon.HistorySize) * mon.Interval * time.Second; time.Since(state.SavedAt) > maxAge {
+		logrus.Infof("Discarding state of monitor %s saved %v ago", mon.Name, time.Since(state.SavedAt))
+		return false
+	}
+
+	mon.restore(state)
+	logrus.Infof("Restored state of monitor %s (history: %d/%d)", mon.Name, len(mon.history), mon.HistorySize)
+
+	return true
+}
+
+// saveState snapshots the monitor, only every save_every seconds unless forced
+func (mon *AbstractMonitor) saveState(force bool) {
+	store := mon.config.StateStore
+	if store == nil {
+		return
+	}
+
+	saveEvery := mon.config.State.SaveEvery
+	if saveEvery <= 0 {
+		saveEvery = DefaultStateSaveEvery
+	}
+	if !force && time.Since(mon.lastStateSave) < saveEvery*time.Second {
+		return
+	}
+
+	if err := store.Save(mon.stateKey(), mon.snapshot()); err != nil {
+		logrus.Warnf("Could not save state of monitor %s: %v", mon.Name, err)
+		return
+	}
+	mon.lastStateSave = time.Now()
+}
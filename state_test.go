@@ -0,0 +1,61 @@
+package cachet
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJSONStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cachet-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	mon := &AbstractMonitor{
+		Name:           "api",
+		ComponentID:    3,
+		HistorySize:    4,
+		history:        []bool{true, false, false, true},
+		warningHistory: []bool{false, true, false, false},
+		lastFailReason: "timeout",
+		incident:       &Incident{ID: 7},
+		incidentStart:  time.Now().Add(-time.Hour),
+	}
+
+	store := &JSONStateStore{Path: path}
+	if err := store.Save(mon.stateKey(), mon.snapshot()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new store reads the file back
+	state, err := (&JSONStateStore{Path: path}).Load(mon.stateKey())
+	if err != nil || state == nil {
+		t.Fatalf("expected saved state, got %v (%v)", state, err)
+	}
+	if missing, _ := store.Load("other/1"); missing != nil {
+		t.Errorf("expected no state for an unknown key, got %v", missing)
+	}
+
+	restored := &AbstractMonitor{Name: "api", ComponentID: 3, HistorySize: 3, incident: &Incident{ID: 7}}
+	restored.restore(state)
+	if len(restored.history) != 3 || restored.history[0] != false || !restored.warningHistory[0] {
+		t.Errorf("history should be trimmed to the latest entries, got %v / %v", restored.history, restored.warningHistory)
+	}
+	if restored.lastFailReason != "timeout" {
+		t.Errorf("expected last fail reason to be restored, got %q", restored.lastFailReason)
+	}
+	if !restored.incidentStart.Equal(mon.incidentStart) {
+		t.Errorf("incident start should be restored for the same incident")
+	}
+
+	other := &AbstractMonitor{Name: "api", ComponentID: 3, HistorySize: 4, incident: &Incident{ID: 8}}
+	other.restore(state)
+	if !other.incidentStart.IsZero() {
+		t.Errorf("incident start should not be restored for another incident")
+	}
+}
@@ -0,0 +1,111 @@
+package cachet
+
+import (
+	"net"
+	"regexp"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// DefaultTCPReadSize is the maximum number of bytes read when matching a banner
+const DefaultTCPReadSize = 4096
+
+type TCPMonitor struct {
+	AbstractMonitor `mapstructure:",squash"`
+
+	// sent as-is once the connection is established (optional)
+	Send string
+
+	// compiled to Regexp, matched against the received banner/response
+	ExpectedResponse string `mapstructure:"expected_response"`
+	responseRegexp   *regexp.Regexp
+}
+
+func (monitor *TCPMonitor) test(l *logrus.Entry) bool {
+	// the timeout bounds the whole check, connection included
+	deadline := time.Now().Add(time.Duration(monitor.Timeout * time.Second))
+
+	conn, err := (&net.Dialer{Deadline: deadline}).Dial("tcp", monitor.Target)
+	if err != nil {
+		monitor.lastFailReason = err.Error()
+		l.Infof("TCP connect failure: %s", monitor.lastFailReason)
+		return false
+	}
+	defer conn.Close()
+	monitor.setDetail("address", conn.RemoteAddr().String())
+
+	conn.SetDeadline(deadline)
+
+	if len(monitor.Send) > 0 {
+		if _, err := conn.Write([]byte(monitor.Send)); err != nil {
+			monitor.lastFailReason = "Unable to send payload: " + err.Error()
+			l.Infof("TCP write failure: %s", monitor.lastFailReason)
+			return false
+		}
+	}
+
+	response := ""
+	if monitor.responseRegexp != nil {
+		buf := make([]byte, DefaultTCPReadSize)
+		received := []byte{}
+
+		// keep reading until the banner matches, the peer closes or the deadline expires
+		for len(received) < DefaultTCPReadSize {
+			n, err := conn.Read(buf)
+			received = append(received, buf[:n]...)
+			if monitor.responseRegexp.Match(received) || err != nil {
+				break
+			}
+		}
+
+		response = string(received)
+		monitor.setDetail("response", response)
+		if !monitor.responseRegexp.MatchString(response) {
+			monitor.lastFailReason = "Unexpected response: " + response + ".\nExpected to match: " + monitor.ExpectedResponse
+			l.Infof("TCP response error: Unexpected response")
+			return false
+		}
+	}
+
+	monitor.triggerShellHook(l, "on_success", monitor.ShellHookOnSuccess, response)
+
+	return true
+}
+
+func (mon *TCPMonitor) Validate() []string {
+	mon.Template.Investigating.SetDefault(defaultHTTPInvestigatingTpl)
+	mon.Template.Fixed.SetDefault(defaultHTTPFixedTpl)
+
+	errs := mon.AbstractMonitor.Validate()
+
+	if len(mon.Target) == 0 {
+		errs = append(errs, "'Target' has not been set")
+	} else if _, _, err := net.SplitHostPort(mon.Target); err != nil {
+		errs = append(errs, "'Target' must be in host:port format: "+err.Error())
+	}
+
+	mon.responseRegexp = nil
+	if len(mon.ExpectedResponse) > 0 {
+		exp, err := regexp.Compile(mon.ExpectedResponse)
+		if err != nil {
+			errs = append(errs, "Regexp compilation failure: "+err.Error())
+		} else {
+			mon.responseRegexp = exp
+		}
+	}
+
+	return errs
+}
+
+func (mon *TCPMonitor) Describe() []string {
+	features := mon.AbstractMonitor.Describe()
+	if len(mon.Send) > 0 {
+		features = append(features, "Sends a payload")
+	}
+	if len(mon.ExpectedResponse) > 0 {
+		features = append(features, "Expected response: "+mon.ExpectedResponse)
+	}
+
+	return features
+}
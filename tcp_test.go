@@ -0,0 +1,111 @@
+package cachet
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// serveBanner sends a banner, answers PONG to a PING line then closes the connection
+func serveBanner(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.Write([]byte("SSH-2.0-OpenSSH_7.4\r\n"))
+				conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+				if line, err := bufio.NewReader(conn).ReadString('\n'); err == nil && strings.TrimSpace(line) == "PING" {
+					conn.Write([]byte("PONG\r\n"))
+				}
+			}()
+		}
+	}()
+
+	return ln
+}
+
+func TestTCPMonitor(t *testing.T) {
+	ln := serveBanner(t)
+	defer ln.Close()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := closed.Addr().String()
+	closed.Close()
+
+	l := logrus.WithFields(logrus.Fields{"monitor": "tcp"})
+
+	tests := []struct {
+		target string
+		send   string
+		expect string
+		up     bool
+		reason string
+	}{
+		{ln.Addr().String(), "", "", true, ""},
+		{ln.Addr().String(), "", `^SSH-2\.0-`, true, ""},
+		{ln.Addr().String(), "PING\r\n", "PONG", true, ""},
+		{ln.Addr().String(), "", "^HTTP/", false, "Unexpected response: SSH-2.0-OpenSSH_7.4\r\n.\nExpected to match: ^HTTP/"},
+		{refused, "", "", false, "connection refused"},
+	}
+
+	for _, test := range tests {
+		mon := &TCPMonitor{AbstractMonitor: AbstractMonitor{Name: "tcp", Target: test.target, ComponentID: 1, Interval: 5, Timeout: 2}, Send: test.send, ExpectedResponse: test.expect}
+		if errs := mon.Validate(); len(errs) > 0 {
+			t.Fatalf("unexpected validation errors: %v", errs)
+		}
+
+		start := time.Now()
+		up := mon.test(l)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s %q: expected the check to end with the connection, took %v", test.target, test.expect, elapsed)
+		}
+		if up != test.up || !strings.Contains(mon.lastFailReason, test.reason) {
+			t.Errorf("%s %q: expected up=%t (%q), got up=%t (%q)", test.target, test.expect, test.up, test.reason, up, mon.lastFailReason)
+		}
+	}
+}
+
+func TestTCPMonitorTimeout(t *testing.T) {
+	// the kernel completes the handshake, nothing is ever sent
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	mon := &TCPMonitor{AbstractMonitor: AbstractMonitor{Name: "tcp", Target: ln.Addr().String(), ComponentID: 1, Interval: 5, Timeout: 1}, ExpectedResponse: "^220 "}
+	if errs := mon.Validate(); len(errs) > 0 {
+		t.Fatalf("unexpected validation errors: %v", errs)
+	}
+
+	start := time.Now()
+	if mon.test(logrus.WithFields(logrus.Fields{"monitor": "tcp"})) {
+		t.Error("expected a silent server to fail the check")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 2*time.Second {
+		t.Errorf("expected the check to end after the timeout, took %v", elapsed)
+	}
+}
+
+func TestTCPMonitorValidate(t *testing.T) {
+	mon := &TCPMonitor{AbstractMonitor: AbstractMonitor{Name: "tcp", Target: "localhost", ComponentID: 1}, ExpectedResponse: "("}
+	if errs := mon.Validate(); len(errs) != 2 {
+		t.Errorf("expected the target and regexp to be rejected, got %v", errs)
+	}
+}
@@ -42,6 +42,11 @@ func (t *MessageTemplate) Exec(data interface{}) (string, string) {
 }
 
 func (t *MessageTemplate) exec(tpl *template.Template, data interface{}) string {
+	if tpl == nil {
+		// empty template
+		return ""
+	}
+
 	buf := new(bytes.Buffer)
 
 	tpl.Execute(buf, data)
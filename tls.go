@@ -0,0 +1,252 @@
+package cachet
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const DefaultTLSWarnDays = 30
+const DefaultTLSCriticalDays = 7
+
+// postgres SSLRequest code (1234 << 16 | 5679)
+const postgresSSLRequestCode = 80877103
+
+// Investigating template
+var defaultTLSInvestigatingTpl = MessageTemplate{
+	Subject: `{{ .Monitor.Name }} - {{ .SystemName }}`,
+	Message: `{{ .Monitor.Name }} certificate check **failed** (server time: {{ .now }})
+
+{{ .FailReason }}`,
+}
+
+type TLSMonitor struct {
+	AbstractMonitor `mapstructure:",squash"`
+
+	// SNI / verified hostname (defaults to the target host)
+	ServerName string `mapstructure:"server_name"`
+	// smtp / imap / postgres (empty for direct TLS)
+	StartTLS string `mapstructure:"starttls"`
+
+	WarnDays     int `mapstructure:"warn_days"`
+	CriticalDays int `mapstructure:"critical_days"`
+
+	// trusted CAs (nil for the system ones)
+	roots *x509.CertPool
+}
+
+func (monitor *TLSMonitor) test(l *logrus.Entry) bool {
+	// the timeout bounds the whole check, connection included
+	deadline := time.Now().Add(time.Duration(monitor.Timeout * time.Second))
+
+	conn, err := (&net.Dialer{Deadline: deadline}).Dial("tcp", monitor.Target)
+	if err != nil {
+		monitor.lastFailReason = err.Error()
+		l.Infof("TLS connect failure: %s", monitor.lastFailReason)
+		return false
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(deadline)
+
+	return monitor.checkConn(l, conn)
+}
+
+// checkConn negotiates TLS on an established connection and checks the peer certificate
+func (monitor *TLSMonitor) checkConn(l *logrus.Entry, conn net.Conn) bool {
+	if err := startTLS(conn, monitor.StartTLS); err != nil {
+		monitor.lastFailReason = "STARTTLS (" + monitor.StartTLS + ") failure: " + err.Error()
+		l.Infof("%s", monitor.lastFailReason)
+		return false
+	}
+
+	// the chain is verified below so that expiry can still be reported on invalid chains
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         monitor.ServerName,
+		InsecureSkipVerify: true,
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		monitor.lastFailReason = "TLS handshake failure: " + err.Error()
+		l.Infof("%s", monitor.lastFailReason)
+		return false
+	}
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		monitor.lastFailReason = "No peer certificate presented"
+		l.Infof("%s", monitor.lastFailReason)
+		return false
+	}
+
+	leaf := certs[0]
+	daysRemaining := int(time.Until(leaf.NotAfter).Hours() / 24)
+	monitor.addMetricPoint("days remaining", monitor.Metrics.DaysRemaining, float64(daysRemaining))
+	monitor.setDetail("subject", leaf.Subject.CommonName)
+	monitor.setDetail("not_after", leaf.NotAfter)
+	monitor.setDetail("days_remaining", daysRemaining)
+
+	expiry := "Certificate '" + leaf.Subject.CommonName + "' expires on " + leaf.NotAfter.Format(monitor.config.DateFormat) + " (" + strconv.Itoa(daysRemaining) + " days)"
+	l.Debugf("%s", expiry)
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{DNSName: monitor.ServerName, Intermediates: intermediates, Roots: monitor.roots}); err != nil {
+		monitor.lastFailReason = "Certificate verification failure: " + err.Error() + "\n" + expiry
+		l.Infof("TLS check failure: %s", err)
+		return false
+	}
+
+	if daysRemaining < monitor.CriticalDays {
+		monitor.lastFailReason = expiry + ".\nExpected at least " + strconv.Itoa(monitor.CriticalDays) + " days"
+		l.Infof("TLS check failure: %s", expiry)
+		return false
+	}
+
+	if daysRemaining < monitor.WarnDays {
+		monitor.warning = true
+		monitor.lastFailReason = expiry + ".\nExpected at least " + strconv.Itoa(monitor.WarnDays) + " days"
+		l.Infof("TLS check warning: %s", expiry)
+		return false
+	}
+
+	monitor.triggerShellHook(l, "on_success", monitor.ShellHookOnSuccess, expiry)
+
+	return true
+}
+
+// startTLS negotiates an in-protocol TLS upgrade
+func startTLS(conn net.Conn, protocol string) error {
+	reader := bufio.NewReader(conn)
+
+	switch protocol {
+	case "smtp":
+		if _, err := readSMTPReply(reader, "220"); err != nil {
+			return err
+		}
+		fmt.Fprintf(conn, "EHLO cachet-monitor\r\n")
+		reply, err := readSMTPReply(reader, "250")
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(strings.ToUpper(reply), "STARTTLS") {
+			return errors.New("server does not advertise STARTTLS")
+		}
+		fmt.Fprintf(conn, "STARTTLS\r\n")
+		_, err = readSMTPReply(reader, "220")
+		return err
+	case "imap":
+		greeting, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(greeting, "* OK") {
+			return errors.New("unexpected greeting: " + strings.TrimSpace(greeting))
+		}
+		fmt.Fprintf(conn, "a001 STARTTLS\r\n")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(line, "a001 ") {
+				if !strings.HasPrefix(line, "a001 OK") {
+					return errors.New("unexpected reply: " + strings.TrimSpace(line))
+				}
+				return nil
+			}
+		}
+	case "postgres":
+		request := make([]byte, 8)
+		binary.BigEndian.PutUint32(request[0:4], 8)
+		binary.BigEndian.PutUint32(request[4:8], postgresSSLRequestCode)
+		if _, err := conn.Write(request); err != nil {
+			return err
+		}
+		answer, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		if answer != 'S' {
+			return errors.New("server refused SSL")
+		}
+	}
+
+	return nil
+}
+
+// readSMTPReply reads a (multi-line) SMTP reply and checks its code
+func readSMTPReply(reader *bufio.Reader, code string) (string, error) {
+	reply := ""
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return reply, err
+		}
+		reply += line
+
+		if len(line) < 4 || line[:3] != code {
+			return reply, errors.New("unexpected reply: " + strings.TrimSpace(line))
+		}
+		if line[3] == ' ' {
+			return reply, nil
+		}
+	}
+}
+
+func (mon *TLSMonitor) Validate() []string {
+	mon.Template.Investigating.SetDefault(defaultTLSInvestigatingTpl)
+	mon.Template.Fixed.SetDefault(defaultHTTPFixedTpl)
+
+	errs := mon.AbstractMonitor.Validate()
+
+	if len(mon.Target) == 0 {
+		errs = append(errs, "'Target' has not been set")
+	} else if host, _, err := net.SplitHostPort(mon.Target); err != nil {
+		errs = append(errs, "'Target' must be in host:port format: "+err.Error())
+	} else if len(mon.ServerName) == 0 {
+		mon.ServerName = host
+	}
+
+	mon.StartTLS = strings.ToLower(mon.StartTLS)
+	switch mon.StartTLS {
+	case "", "smtp", "imap", "postgres":
+		break
+	default:
+		errs = append(errs, "Unsupported STARTTLS protocol: "+mon.StartTLS)
+	}
+
+	if mon.WarnDays <= 0 {
+		mon.WarnDays = DefaultTLSWarnDays
+	}
+	if mon.CriticalDays <= 0 {
+		mon.CriticalDays = DefaultTLSCriticalDays
+	}
+	if mon.CriticalDays > mon.WarnDays {
+		errs = append(errs, "'critical_days' greater than 'warn_days'")
+	}
+
+	return errs
+}
+
+func (mon *TLSMonitor) Describe() []string {
+	features := mon.AbstractMonitor.Describe()
+	features = append(features, "Server name: "+mon.ServerName)
+	if len(mon.StartTLS) > 0 {
+		features = append(features, "STARTTLS: "+mon.StartTLS)
+	}
+	features = append(features, "Warn/critical days: "+strconv.Itoa(mon.WarnDays)+"/"+strconv.Itoa(mon.CriticalDays))
+	features = append(features, "Days remaining metrics: "+strconv.Itoa(len(mon.Metrics.DaysRemaining)))
+
+	return features
+}
@@ -0,0 +1,191 @@
+package cachet
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// selfSignedCert returns a certificate for example.test expiring in the given number of days
+func selfSignedCert(t *testing.T, days int) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "example.test"},
+		DNSNames:              []string{"example.test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Duration(days) * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, roots
+}
+
+func TestTLSMonitor(t *testing.T) {
+	cert, roots := selfSignedCert(t, 10)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	// the monitor closes the connection after the handshake
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	l := logrus.WithFields(logrus.Fields{"monitor": "tls"})
+	cfg := &CachetMonitor{DateFormat: DefaultTimeFormat}
+
+	tests := []struct {
+		name         string
+		serverName   string
+		warnDays     int
+		criticalDays int
+		up           bool
+		warning      bool
+		reason       string
+	}{
+		{"valid", "example.test", 5, 3, true, false, ""},
+		{"warning", "example.test", 30, 7, false, true, "Expected at least 30 days"},
+		{"critical", "example.test", 30, 20, false, false, "Expected at least 20 days"},
+		{"hostname mismatch", "other.test", 5, 3, false, false, "Certificate verification failure"},
+	}
+
+	for _, test := range tests {
+		mon := &TLSMonitor{
+			AbstractMonitor: AbstractMonitor{Name: "tls", Target: srv.Listener.Addr().String(), ComponentID: 1, Interval: 5, Timeout: 2},
+			ServerName:      test.serverName,
+			WarnDays:        test.warnDays,
+			CriticalDays:    test.criticalDays,
+			roots:           roots,
+		}
+		mon.config = cfg
+		if errs := mon.Validate(); len(errs) > 0 {
+			t.Fatalf("%s: unexpected validation errors: %v", test.name, errs)
+		}
+
+		up := mon.test(l)
+		if up != test.up || mon.warning != test.warning || !strings.Contains(mon.lastFailReason, test.reason) {
+			t.Errorf("%s: expected up=%t warning=%t (%q), got up=%t warning=%t (%q)", test.name, test.up, test.warning, test.reason, up, mon.warning, mon.lastFailReason)
+		}
+		if days := mon.details["days_remaining"]; days != 9 {
+			t.Errorf("%s: expected 9 days remaining, got %v", test.name, days)
+		}
+	}
+}
+
+func TestTLSMonitorStartTLS(t *testing.T) {
+	cert, roots := selfSignedCert(t, 90)
+	l := logrus.WithFields(logrus.Fields{"monitor": "tls"})
+
+	// server side of each protocol, up to the TLS handshake
+	servers := map[string]func(conn net.Conn, reader *bufio.Reader) bool{
+		"smtp": func(conn net.Conn, reader *bufio.Reader) bool {
+			conn.Write([]byte("220 mail.example.test ESMTP\r\n"))
+			if line, _ := reader.ReadString('\n'); !strings.HasPrefix(line, "EHLO ") {
+				return false
+			}
+			conn.Write([]byte("250-mail.example.test\r\n250-PIPELINING\r\n250 STARTTLS\r\n"))
+			if line, _ := reader.ReadString('\n'); line != "STARTTLS\r\n" {
+				return false
+			}
+			conn.Write([]byte("220 Ready to start TLS\r\n"))
+			return true
+		},
+		"imap": func(conn net.Conn, reader *bufio.Reader) bool {
+			conn.Write([]byte("* OK IMAP4rev1 ready\r\n"))
+			if line, _ := reader.ReadString('\n'); line != "a001 STARTTLS\r\n" {
+				return false
+			}
+			conn.Write([]byte("* CAPABILITY IMAP4rev1\r\na001 OK Begin TLS negotiation now\r\n"))
+			return true
+		},
+		"postgres": func(conn net.Conn, reader *bufio.Reader) bool {
+			request := make([]byte, 8)
+			if _, err := reader.Read(request); err != nil {
+				return false
+			}
+			conn.Write([]byte("S"))
+			return true
+		},
+	}
+
+	for protocol, serve := range servers {
+		client, server := net.Pipe()
+		go func(serve func(net.Conn, *bufio.Reader) bool) {
+			defer server.Close()
+			if serve(server, bufio.NewReader(server)) {
+				tls.Server(server, &tls.Config{Certificates: []tls.Certificate{cert}}).Handshake()
+			}
+		}(serve)
+
+		mon := &TLSMonitor{
+			AbstractMonitor: AbstractMonitor{Name: "tls", Target: "example.test:25", ComponentID: 1, Interval: 5, Timeout: 2},
+			StartTLS:        protocol,
+			roots:           roots,
+		}
+		mon.config = &CachetMonitor{DateFormat: DefaultTimeFormat}
+		if errs := mon.Validate(); len(errs) > 0 {
+			t.Fatalf("%s: unexpected validation errors: %v", protocol, errs)
+		}
+
+		if !mon.checkConn(l, client) {
+			t.Errorf("%s: expected the certificate to be checked after STARTTLS, got %q", protocol, mon.lastFailReason)
+		}
+		client.Close()
+	}
+
+	// SMTP server without STARTTLS
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		reader := bufio.NewReader(server)
+		server.Write([]byte("220 mail.example.test ESMTP\r\n"))
+		reader.ReadString('\n')
+		server.Write([]byte("250 mail.example.test\r\n"))
+	}()
+	if err := startTLS(client, "smtp"); err == nil || err.Error() != "server does not advertise STARTTLS" {
+		t.Errorf("expected STARTTLS to be missing, got %v", err)
+	}
+	client.Close()
+}
+
+func TestTLSMonitorValidate(t *testing.T) {
+	mon := &TLSMonitor{AbstractMonitor: AbstractMonitor{Name: "tls", Target: "example.test:443", ComponentID: 1}, StartTLS: "FTP", WarnDays: 5, CriticalDays: 10}
+	if errs := mon.Validate(); len(errs) != 2 {
+		t.Errorf("expected the protocol and days to be rejected, got %v", errs)
+	}
+	if mon.ServerName != "example.test" {
+		t.Errorf("expected the server name to default to the target host, got %q", mon.ServerName)
+	}
+}